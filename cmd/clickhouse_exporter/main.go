@@ -7,7 +7,6 @@ import (
 	"github.com/ClickHouse/clickhouse_exporter/pkg/configs"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog/log"
 )
 
@@ -15,24 +14,27 @@ func main() {
 
 	configurations := configs.LoadConfigs()
 
-	registerer := prometheus.DefaultRegisterer
 	gatherer := prometheus.DefaultGatherer
 	if *configurations.ClickhouseOnly {
-		reg := prometheus.NewRegistry()
-		registerer = reg
-		gatherer = reg
+		gatherer = prometheus.NewRegistry()
 	}
 
 	e := exporter.NewExporterHolder(configurations)
-	registerer.MustRegister(e)
+	if *configurations.CollectorInterval > 0 {
+		e.StartBackgroundScrape(*configurations.CollectorInterval)
+	}
 
-	http.Handle(*configurations.MetricsEndpoint, promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+	http.Handle(*configurations.MetricsEndpoint, e.Handler(gatherer, *configurations.TimeoutOffset))
+	http.Handle("/probe", exporter.NewProber(configurations))
+	http.Handle("/sd/targets", exporter.NewDiscoverer(configurations))
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
 			<head><title>Clickhouse Exporter</title></head>
 			<body>
 			<h1>Clickhouse Exporter</h1>
 			<p><a href="` + *configurations.MetricsEndpoint + `">Metrics</a></p>
+			<p><a href="/probe?target=127.0.0.1:8123&module=default">Probe</a></p>
+			<p><a href="/sd/targets">Service discovery</a></p>
 			</body>
 			</html>`))
 	})
@@ -0,0 +1,125 @@
+package exporter
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/ClickHouse/clickhouse_exporter/pkg/clickhouse"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/configs"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	CLUSTERS_DISCOVERY_QUERY = `select cluster, shard_num, replica_num, host_name, port from system.clusters order by cluster, shard_num, replica_num`
+)
+
+// targetGroup is an entry of the Prometheus HTTP service discovery format.
+type targetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+type clusterNode struct {
+	Cluster    string `ch:"cluster"`
+	ShardNum   int    `ch:"shard_num"`
+	ReplicaNum int    `ch:"replica_num"`
+	HostName   string `ch:"host_name"`
+	Port       int    `ch:"port"`
+}
+
+// Discoverer lists the nodes of the clusters known to the seed node, the one of
+// CLICKHOUSE_URI, in the Prometheus HTTP service discovery format.
+type Discoverer struct {
+	seed      url.URL
+	queryURI  string
+	clickConn clickhouse.ClickhouseConn
+}
+
+func NewDiscoverer(configs configs.Configuration) *Discoverer {
+	uri, err := url.Parse(configs.ClickhouseScrapeURI)
+	if err != nil {
+		log.Fatal().Err(err).Send()
+	}
+
+	clickConn, err := clickhouse.NewClickhouseConn(
+		*uri,
+		configs.User,
+		configs.Password,
+		&tls.Config{InsecureSkipVerify: *configs.Insecure},
+		30*time.Second,
+	)
+	if err != nil {
+		log.Fatal().Err(err).Send()
+	}
+	clickConn.SkipMaxExecutionTime = !*configs.MaxExecutionTime
+
+	url_values := uri.Query()
+	queryURI := *uri
+	url_values.Set("query", CLUSTERS_DISCOVERY_QUERY)
+	queryURI.RawQuery = url_values.Encode()
+
+	return &Discoverer{
+		seed:      *uri,
+		queryURI:  queryURI.String(),
+		clickConn: clickConn,
+	}
+}
+
+// ServeHTTP answers with one target group per node, labeled with its cluster,
+// shard and replica. The optional cluster parameter keeps a single cluster.
+// Nodes are reached on the port of the seed uri, except with the native
+// protocol where system.clusters knows the port of each node.
+func (d *Discoverer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	result, err := d.clickConn.Query(r.Context(), d.queryURI)
+	if err != nil {
+		log.Error().Err(err).Msg("can't read system.clusters")
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	var nodes []clusterNode
+	if err := result.Decode(&nodes); err != nil {
+		log.Error().Err(err).Msg("can't read system.clusters")
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	cluster := r.URL.Query().Get("cluster")
+	groups := make([]targetGroup, 0, len(nodes))
+	for _, node := range nodes {
+		if cluster != "" && node.Cluster != cluster {
+			continue
+		}
+		groups = append(groups, targetGroup{
+			Targets: []string{d.targetAddress(node)},
+			Labels: map[string]string{
+				"cluster": node.Cluster,
+				"shard":   strconv.Itoa(node.ShardNum),
+				"replica": strconv.Itoa(node.ReplicaNum),
+			},
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(groups); err != nil {
+		log.Error().Err(err).Msg("can't write service discovery response")
+	}
+}
+
+func (d *Discoverer) targetAddress(node clusterNode) string {
+	port := d.seed.Port()
+	switch {
+	case d.seed.Scheme == "clickhouse" || d.seed.Scheme == "clickhouses":
+		port = strconv.Itoa(node.Port)
+	case port == "" && d.seed.Scheme == "https":
+		port = "443"
+	case port == "":
+		port = "80"
+	}
+	return net.JoinHostPort(node.HostName, port)
+}
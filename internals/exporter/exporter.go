@@ -1,9 +1,12 @@
 package exporter
 
 import (
+	"context"
 	"crypto/tls"
-	"net/http"
+	"errors"
+	"fmt"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/ClickHouse/clickhouse_exporter/internals/exporters"
@@ -19,19 +22,45 @@ const (
 	NAMESPACE = "clickhouse" // For Prometheus metrics.
 )
 
+var (
+	collectorSuccessDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(NAMESPACE, "exporter", "collector_success"),
+		"Was the last scrape of the collector successful.",
+		[]string{"collector"}, nil,
+	)
+	collectorDurationDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(NAMESPACE, "exporter", "collector_duration_seconds"),
+		"Duration of the last scrape of the collector in seconds.",
+		[]string{"collector"}, nil,
+	)
+	collectorLastSuccessDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(NAMESPACE, "exporter", "collector_last_success_timestamp_seconds"),
+		"Unix time of the last successful scrape of the collector.",
+		[]string{"collector"}, nil,
+	)
+)
+
 // Exporter collects clickhouse stats from the given URI and exports them using
 // the prometheus metrics package.
 type ExporterHolder struct {
-	basicMetricsExporter exporters.BasicMetricsExporter
-	asyncMetricsExporter exporters.AsyncMetricsExporter
-	eventMetricsExporter exporters.EventMetricsExporter
-	partMetricsExporter  exporters.PartsMetricsExporter
-	diskMetricsExporter  exporters.DiskMetricsExporter
-	queryMetricsExporter exporters.QueryMetricsExporter
-	tableMetricsExporter exporters.TableMetricsExporter
+	collectors  []namedCollector
+	concurrency int
 
 	scrapeFailures prometheus.Counter
 	clickConn      clickhouse.ClickhouseConn
+
+	lastSuccessMu sync.Mutex
+	lastSuccess   map[string]time.Time
+
+	background bool
+	snapshotMu sync.RWMutex
+	snapshot   *snapshot
+}
+
+type namedCollector struct {
+	name      string
+	collector exporters.Collector
+	timeout   time.Duration
 }
 
 // NewExporter returns an initialized Exporter.
@@ -45,121 +74,205 @@ func NewExporterHolder(configs configs.Configuration) *ExporterHolder {
 
 	queryFilters := yaml.ReadYaml(configs.QueryFiltersPath)
 
-	basicMetricsExporter := exporters.NewBasicMetricsExporter(
-		*uri,
-		NAMESPACE,
-		queryFilters.GetMapObject("basic_exporter"),
-	)
+	if configs.Cluster != "" {
+		log.Printf("Scraping every replica of cluster %s", configs.Cluster)
+	}
 
-	asyncMetricsExporter := exporters.NewAsyncMetricsExporter(
-		*uri,
-		NAMESPACE,
-		queryFilters.GetMapObject("async_exporter"),
-	)
+	target := scrapeTarget{
+		uri:      *uri,
+		user:     configs.User,
+		password: configs.Password,
+		cluster:  configs.Cluster,
+	}
+	holder, err := newExporterHolder(target, queryFilters, nil, configs)
+	if err != nil {
+		log.Fatal().Err(err).Send()
+	}
+	return holder
+}
 
-	eventMetricsExporter := exporters.NewEventMetricsExporter(
-		*uri,
-		NAMESPACE,
-		queryFilters.GetMapObject("event_exporter"),
-	)
+// scrapeTarget is the clickhouse node a holder scrapes, and the cluster scraped
+// through it if any.
+type scrapeTarget struct {
+	uri      url.URL
+	user     string
+	password string
+	cluster  string
+}
 
-	partMetricsExporter := exporters.NewPartsMetricsExporter(
-		*uri,
-		NAMESPACE,
-		queryFilters.GetMapObject("parts_exporter"),
+// newExporterHolder builds a holder scraping target. When collectorNames is nil
+// every collector enabled in the query filters is used, otherwise exactly the
+// given ones.
+func newExporterHolder(target scrapeTarget, queryFilters yaml.YamlConfig, collectorNames []string, configs configs.Configuration) (*ExporterHolder, error) {
+	clickConn, err := clickhouse.NewClickhouseConn(
+		target.uri,
+		target.user,
+		target.password,
+		&tls.Config{InsecureSkipVerify: *configs.Insecure},
+		30*time.Second,
 	)
+	if err != nil {
+		return nil, err
+	}
+	clickConn.SkipMaxExecutionTime = !*configs.MaxExecutionTime
 
-	diskMetricsExporter := exporters.NewDiskMetricsExporter(
-		*uri,
-		NAMESPACE,
-		queryFilters.GetMapObject("disk_exporter"),
-	)
+	selected := collectorNames != nil
+	if !selected {
+		collectorNames = exporters.AllCollectorNames(queryFilters)
+	}
 
-	queryMetricsExporter := exporters.NewQueryMetricsExporter(
-		*uri,
-		NAMESPACE,
-		queryFilters.GetMapObject("query_exporter"),
-	)
+	var collectors []namedCollector
+	for _, name := range collectorNames {
+		collectorConfig := queryFilters.GetMapObject(name)
+		if !selected && !collectorConfig.GetBool("enabled", true) {
+			log.Printf("%s is disabled", name)
+			continue
+		}
 
-	tableMetricsExporter := exporters.NewTableMetricsExporter(
-		*uri,
-		NAMESPACE,
-		queryFilters.GetMapObject("table_exporter"),
-	)
+		collector, err := exporters.NewCollector(name, target.uri, NAMESPACE, collectorConfig, target.cluster)
+		if err != nil {
+			return nil, err
+		}
+		collectors = append(collectors, namedCollector{
+			name:      name,
+			collector: collector,
+			timeout:   collectorConfig.GetDuration("timeout", *configs.CollectorTimeout),
+		})
+	}
 
 	return &ExporterHolder{
-		basicMetricsExporter: basicMetricsExporter,
-		asyncMetricsExporter: asyncMetricsExporter,
-		eventMetricsExporter: eventMetricsExporter,
-		partMetricsExporter:  partMetricsExporter,
-		diskMetricsExporter:  diskMetricsExporter,
-		queryMetricsExporter: queryMetricsExporter,
-		tableMetricsExporter: tableMetricsExporter,
+		collectors:  collectors,
+		concurrency: max(*configs.CollectorConcurrency, 1),
 		scrapeFailures: prometheus.NewCounter(prometheus.CounterOpts{
 			Namespace: NAMESPACE,
 			Name:      "exporter_scrape_failures_total",
 			Help:      "Number of errors while scraping clickhouse.",
 		}),
-		clickConn: clickhouse.ClickhouseConn{
-			Client: &http.Client{
-				Transport: &http.Transport{
-					TLSClientConfig: &tls.Config{InsecureSkipVerify: *configs.Insecure},
-				},
-				Timeout: 30 * time.Second,
-			},
-			User:     configs.User,
-			Password: configs.Password,
-		},
+		clickConn:   clickConn,
+		lastSuccess: make(map[string]time.Time),
+	}, nil
+}
+
+// Describe sends no descriptors, which makes ExporterHolder an unchecked
+// collector. The metrics of clickhouse are only known once queried, and
+// registering the holder must neither cost a scrape nor need clickhouse to be up.
+// It implements prometheus.Collector.
+func (e *ExporterHolder) Describe(ch chan<- *prometheus.Desc) {}
+
+func (e *ExporterHolder) collect(ctx context.Context, ch chan<- prometheus.Metric) error {
+
+	// collectors run in parallel, at most e.concurrency of them at a time
+	semaphore := make(chan struct{}, e.concurrency)
+	errs := make([]error, len(e.collectors))
+
+	var wg sync.WaitGroup
+	for i, c := range e.collectors {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			errs[i] = e.scrapCollector(ctx, c, ch)
+		}()
 	}
+	wg.Wait()
+
+	return errors.Join(errs...)
 }
 
-// Describe describes all the metrics ever exported by the clickhouse exporter. It
-// implements prometheus.Collector.
-func (e *ExporterHolder) Describe(ch chan<- *prometheus.Desc) {
-	// We cannot know in advance what metrics the exporter will generate
-	// from clickhouse. So we use the poor man's describe method: Run a collect
-	// and send the descriptors of all the collected metrics.
+// scrapCollector runs a single collector and reports its success, duration and
+// last success time alongside the metrics it produced.
+func (e *ExporterHolder) scrapCollector(ctx context.Context, c namedCollector, ch chan<- prometheus.Metric) error {
+	start := time.Now()
+	metrics, err := e.scrapWithTimeout(ctx, c)
+	duration := time.Since(start)
 
-	metricCh := make(chan prometheus.Metric)
-	doneCh := make(chan struct{})
+	success := 1.0
+	if err != nil {
+		success = 0
+		err = fmt.Errorf("collector %s: %w", c.name, err)
+	}
+	for _, m := range metrics {
+		ch <- m
+	}
+
+	e.lastSuccessMu.Lock()
+	if err == nil {
+		e.lastSuccess[c.name] = start
+	}
+	lastSuccess, succeededBefore := e.lastSuccess[c.name]
+	e.lastSuccessMu.Unlock()
 
+	ch <- prometheus.MustNewConstMetric(collectorSuccessDesc, prometheus.GaugeValue, success, c.name)
+	ch <- prometheus.MustNewConstMetric(collectorDurationDesc, prometheus.GaugeValue, duration.Seconds(), c.name)
+	if succeededBefore {
+		ch <- prometheus.MustNewConstMetric(collectorLastSuccessDesc, prometheus.GaugeValue, float64(lastSuccess.UnixNano())/1e9, c.name)
+	}
+
+	return err
+}
+
+// scrapWithTimeout buffers the metrics of a collector and gives up on it once
+// its deadline is reached, so a slow collector never holds back the others.
+func (e *ExporterHolder) scrapWithTimeout(ctx context.Context, c namedCollector) ([]prometheus.Metric, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	metricCh := make(chan prometheus.Metric)
+	drained := make(chan struct{})
+	var metrics []prometheus.Metric
 	go func() {
 		for m := range metricCh {
-			ch <- m.Desc()
+			metrics = append(metrics, m)
 		}
-		close(doneCh)
+		close(drained)
 	}()
 
-	e.Collect(metricCh)
-	close(metricCh)
-	<-doneCh
-}
-
-func (e *ExporterHolder) collect(ch chan<- prometheus.Metric) error {
-
-	e.asyncMetricsExporter.Scrap(e.clickConn, ch)
-	e.basicMetricsExporter.Scrap(e.clickConn, ch)
-	e.diskMetricsExporter.Scrap(e.clickConn, ch)
-	e.eventMetricsExporter.Scrap(e.clickConn, ch)
-	e.partMetricsExporter.Scrap(e.clickConn, ch)
-	e.queryMetricsExporter.Scrap(e.clickConn, ch)
-	e.tableMetricsExporter.Scrap(e.clickConn, ch)
+	done := make(chan error, 1)
+	go func() {
+		err := c.collector.Scrap(ctx, e.clickConn, metricCh)
+		close(metricCh)
+		done <- err
+	}()
 
-	return nil
+	select {
+	case err := <-done:
+		<-drained
+		return metrics, err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("gave up on collector: %w", ctx.Err())
+	}
 }
 
 // Collect fetches the stats from configured clickhouse location and delivers them
 // as Prometheus metrics. It implements prometheus.Collector.
 func (e *ExporterHolder) Collect(ch chan<- prometheus.Metric) {
+	e.serve(context.Background(), ch)
+}
+
+// serve sends the latest snapshot when scraping in the background, and scrapes
+// clickhouse bound to ctx otherwise.
+func (e *ExporterHolder) serve(ctx context.Context, ch chan<- prometheus.Metric) {
+	if e.background {
+		e.collectSnapshot(ch)
+		return
+	}
+	e.collectWithContext(ctx, ch)
+}
+
+// collectWithContext is Collect bound to ctx, every query of the scrape is
+// cancelled once ctx is done.
+func (e *ExporterHolder) collectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
 	upValue := 1
 
-	if err := e.collect(ch); err != nil {
+	if err := e.collect(ctx, ch); err != nil {
 		log.Error().Msgf("Error scraping clickhouse: %s", err)
 		e.scrapeFailures.Inc()
-		e.scrapeFailures.Collect(ch)
 
 		upValue = 0
 	}
+	e.scrapeFailures.Collect(ch)
 
 	ch <- prometheus.MustNewConstMetric(
 		prometheus.NewDesc(
@@ -0,0 +1,74 @@
+package exporter
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	scrapeTimeoutHeader = "X-Prometheus-Scrape-Timeout-Seconds"
+)
+
+// scrapeCollector binds a single scrape of the holder to the context of the
+// /metrics request that triggered it.
+type scrapeCollector struct {
+	ctx    context.Context
+	holder *ExporterHolder
+}
+
+func (s *scrapeCollector) Describe(ch chan<- *prometheus.Desc) {
+	s.holder.Describe(ch)
+}
+
+func (s *scrapeCollector) Collect(ch chan<- prometheus.Metric) {
+	s.holder.serve(s.ctx, ch)
+}
+
+// Handler serves the metrics of gatherer together with a fresh scrape of the
+// holder. The scrape is cancelled when the request goes away and gets the
+// deadline Prometheus announces in the X-Prometheus-Scrape-Timeout-Seconds
+// header, minus timeoutOffset to leave time for sending the response.
+func (e *ExporterHolder) Handler(gatherer prometheus.Gatherer, timeoutOffset time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if timeout, ok := scrapeTimeout(r, timeoutOffset); ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(&scrapeCollector{ctx: ctx, holder: e})
+
+		promhttp.HandlerFor(
+			prometheus.Gatherers{gatherer, registry},
+			promhttp.HandlerOpts{},
+		).ServeHTTP(w, r)
+	})
+}
+
+func scrapeTimeout(r *http.Request, timeoutOffset time.Duration) (time.Duration, bool) {
+	header := r.Header.Get(scrapeTimeoutHeader)
+	if header == "" {
+		return 0, false
+	}
+
+	seconds, err := strconv.ParseFloat(header, 64)
+	if err != nil {
+		log.Error().Err(err).Msgf("can't parse %s header", scrapeTimeoutHeader)
+		return 0, false
+	}
+
+	timeout := time.Duration(seconds*float64(time.Second)) - timeoutOffset
+	if timeout <= 0 {
+		log.Error().Msgf("%s of %s is not larger than the timeout offset %s", scrapeTimeoutHeader, header, timeoutOffset)
+		return 0, false
+	}
+	return timeout, true
+}
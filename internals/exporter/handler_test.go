@@ -0,0 +1,38 @@
+package exporter
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestScrapeTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		offset  time.Duration
+		want    time.Duration
+		wantSet bool
+	}{
+		{name: "no header", offset: 500 * time.Millisecond},
+		{name: "seconds", header: "10", offset: 500 * time.Millisecond, want: 9500 * time.Millisecond, wantSet: true},
+		{name: "fraction of a second", header: "1.5", offset: 0, want: 1500 * time.Millisecond, wantSet: true},
+		{name: "non numeric", header: "10s", offset: 500 * time.Millisecond},
+		{name: "equal to the offset", header: "0.5", offset: 500 * time.Millisecond},
+		{name: "below the offset", header: "0.2", offset: 500 * time.Millisecond},
+		{name: "negative", header: "-1", offset: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/metrics", nil)
+			if tt.header != "" {
+				r.Header.Set(scrapeTimeoutHeader, tt.header)
+			}
+			got, ok := scrapeTimeout(r, tt.offset)
+			if ok != tt.wantSet || got != tt.want {
+				t.Errorf("got %s, %v, want %s, %v", got, ok, tt.want, tt.wantSet)
+			}
+		})
+	}
+}
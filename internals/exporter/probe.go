@@ -0,0 +1,215 @@
+package exporter
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/ClickHouse/clickhouse_exporter/pkg/configs"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/yaml"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultProbeModule = "default"
+	defaultProbeScheme = "http"
+
+	// holders of the least recently probed targets are dropped past this many
+	maxProbeHolders = 256
+)
+
+var errTargetNotAllowed = errors.New("target is not allowed by the module")
+
+// probeModule says how to scrape a probed target: the scheme used when the
+// target has none, the credentials, the cluster scraped through the target,
+// which collectors to run and which targets may be probed.
+type probeModule struct {
+	scheme     string
+	user       string
+	password   string
+	cluster    string
+	collectors []string
+	targets    []*regexp.Regexp
+}
+
+// allows tells whether host, as host:port, may be probed with the module.
+// A module without targets allows any host.
+func (m probeModule) allows(host string) bool {
+	if len(m.targets) == 0 {
+		return true
+	}
+	for _, target := range m.targets {
+		if target.MatchString(host) {
+			return true
+		}
+	}
+	return false
+}
+
+type probeHolder struct {
+	key    string
+	holder *ExporterHolder
+}
+
+// Prober serves /probe?target=host:8123&module=default, scraping any clickhouse
+// node with the collectors and credentials of the module. The holder of each
+// target and module is built on the first probe and reused afterwards, the
+// least recently used ones are dropped past maxProbeHolders.
+type Prober struct {
+	configs      configs.Configuration
+	queryFilters yaml.YamlConfig
+	modules      map[string]probeModule
+
+	holdersMu  sync.Mutex
+	holders    map[string]*list.Element
+	holdersLRU *list.List
+}
+
+// NewProber reads the probe modules, or uses a single default module scraping
+// over http when the modules file does not exist. A module without collectors
+// runs every collector enabled in the query filters.
+//
+// Modules only send the credentials they set. CLICKHOUSE_USER and
+// CLICKHOUSE_PASSWORD are sent with env_credentials: true, which requires the
+// module to restrict the targets it probes, so that they never leak to a node
+// picked by whoever can reach /probe.
+func NewProber(configs configs.Configuration) *Prober {
+	modules := map[string]probeModule{
+		defaultProbeModule: {scheme: defaultProbeScheme},
+	}
+	if _, err := os.Stat(configs.ProbeModulesPath); err == nil {
+		modules = readProbeModules(configs)
+	} else if errors.Is(err, os.ErrNotExist) {
+		log.Warn().Msgf("%s does not exist, /probe only serves the %s module", configs.ProbeModulesPath, defaultProbeModule)
+	} else {
+		panic(err)
+	}
+
+	return &Prober{
+		configs:      configs,
+		queryFilters: yaml.ReadYaml(configs.QueryFiltersPath),
+		modules:      modules,
+		holders:      make(map[string]*list.Element),
+		holdersLRU:   list.New(),
+	}
+}
+
+func readProbeModules(configs configs.Configuration) map[string]probeModule {
+	modulesConfig := yaml.ReadYaml(configs.ProbeModulesPath)
+
+	modules := make(map[string]probeModule)
+	for name := range modulesConfig.GetData() {
+		moduleConfig := modulesConfig.GetMapObject(name)
+		module := probeModule{
+			scheme:     moduleConfig.GetString("scheme", defaultProbeScheme),
+			user:       moduleConfig.GetString("user", ""),
+			password:   moduleConfig.GetString("password", ""),
+			cluster:    moduleConfig.GetString("cluster", ""),
+			collectors: moduleConfig.GetStringList("collectors"),
+		}
+		for _, target := range moduleConfig.GetStringList("targets") {
+			pattern, err := regexp.Compile("^(?:" + target + ")$")
+			if err != nil {
+				panic("error: module " + name + " has an invalid target " + target + ": " + err.Error())
+			}
+			module.targets = append(module.targets, pattern)
+		}
+		if moduleConfig.GetBool("env_credentials", false) {
+			if len(module.targets) == 0 {
+				panic("error: module " + name + " sets env_credentials without targets")
+			}
+			module.user = configs.User
+			module.password = configs.Password
+		}
+		modules[name] = module
+	}
+	return modules
+}
+
+func (p *Prober) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+	moduleName := r.URL.Query().Get("module")
+	if moduleName == "" {
+		moduleName = defaultProbeModule
+	}
+	module, exists := p.modules[moduleName]
+	if !exists {
+		http.Error(w, fmt.Sprintf("unknown module %q", moduleName), http.StatusBadRequest)
+		return
+	}
+
+	holder, err := p.holder(target, moduleName, module)
+	if errors.Is(err, errTargetNotAllowed) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("can't probe %s", target)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	holder.Handler(prometheus.Gatherers{}, *p.configs.TimeoutOffset).ServeHTTP(w, r)
+}
+
+func (p *Prober) holder(target string, moduleName string, module probeModule) (*ExporterHolder, error) {
+	key := moduleName + "/" + target
+
+	p.holdersMu.Lock()
+	defer p.holdersMu.Unlock()
+
+	if element, exists := p.holders[key]; exists {
+		p.holdersLRU.MoveToFront(element)
+		return element.Value.(*probeHolder).holder, nil
+	}
+
+	if !strings.Contains(target, "://") {
+		target = module.scheme + "://" + target
+	}
+	uri, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target: %w", err)
+	}
+	if uri.Host == "" {
+		return nil, fmt.Errorf("invalid target %q: no host", target)
+	}
+	// only the host is checked against the module, a path, settings in the query
+	// or credentials would reach the node unchecked
+	if uri.User != nil || (uri.Path != "" && uri.Path != "/") || uri.RawQuery != "" || uri.ForceQuery || uri.Fragment != "" {
+		return nil, fmt.Errorf("invalid target %q: only scheme://host:port is allowed", uri.Redacted())
+	}
+	if !module.allows(uri.Host) {
+		return nil, fmt.Errorf("%w: %s", errTargetNotAllowed, uri.Host)
+	}
+	uri = &url.URL{Scheme: uri.Scheme, Host: uri.Host}
+
+	log.Printf("Probing %s with module %s", uri, moduleName)
+	probeTarget := scrapeTarget{
+		uri:      *uri,
+		user:     module.user,
+		password: module.password,
+		cluster:  module.cluster,
+	}
+	holder, err := newExporterHolder(probeTarget, p.queryFilters, module.collectors, p.configs)
+	if err != nil {
+		return nil, err
+	}
+	p.holders[key] = p.holdersLRU.PushFront(&probeHolder{key: key, holder: holder})
+	if p.holdersLRU.Len() > maxProbeHolders {
+		oldest := p.holdersLRU.Remove(p.holdersLRU.Back()).(*probeHolder)
+		delete(p.holders, oldest.key)
+	}
+	return holder, nil
+}
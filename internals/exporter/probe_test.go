@@ -0,0 +1,144 @@
+package exporter
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ClickHouse/clickhouse_exporter/pkg/configs"
+)
+
+// fakeClickhouse answers the basic_exporter query and records the user sent
+// with each query.
+type fakeClickhouse struct {
+	mu    sync.Mutex
+	users []string
+}
+
+func (f *fakeClickhouse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.users = append(f.users, r.Header.Get("X-ClickHouse-User"))
+	f.mu.Unlock()
+	w.Write([]byte("metric\tvalue\nString\tFloat64\nQuery\t1\n"))
+}
+
+// takeUsers returns the users recorded since the previous call.
+func (f *fakeClickhouse) takeUsers() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	users := f.users
+	f.users = nil
+	return users
+}
+
+func writeTestFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newTestProber(t *testing.T, modules string) *Prober {
+	t.Helper()
+	timeoutOffset := 500 * time.Millisecond
+	insecure := true
+	concurrency := 4
+	collectorTimeout := 5 * time.Second
+	maxExecutionTime := true
+	return NewProber(configs.Configuration{
+		TimeoutOffset:        &timeoutOffset,
+		Insecure:             &insecure,
+		CollectorConcurrency: &concurrency,
+		CollectorTimeout:     &collectorTimeout,
+		MaxExecutionTime:     &maxExecutionTime,
+		User:                 "env-user",
+		Password:             "env-password",
+		QueryFiltersPath:     writeTestFile(t, "query-filters.yaml", "basic_exporter: {}\n"),
+		ProbeModulesPath:     writeTestFile(t, "probe-modules.yaml", modules),
+	})
+}
+
+func TestProber(t *testing.T) {
+	fake := &fakeClickhouse{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	prober := newTestProber(t, "open:\n  collectors: [basic_exporter]\n"+
+		"internal:\n  env_credentials: true\n  collectors: [basic_exporter]\n  targets:\n    - '127\\.0\\.0\\.1:[0-9]+'\n")
+
+	tests := []struct {
+		name       string
+		target     string
+		module     string
+		wantStatus int
+		wantUsers  []string
+	}{
+		{name: "module without targets sends no credentials", target: host, module: "open", wantStatus: http.StatusOK, wantUsers: []string{""}},
+		{name: "allowed target gets the env credentials", target: host, module: "internal", wantStatus: http.StatusOK, wantUsers: []string{"env-user"}},
+		{name: "target with a scheme", target: "http://" + host + "/", module: "internal", wantStatus: http.StatusOK, wantUsers: []string{"env-user"}},
+		{name: "disallowed host", target: "ch-1.internal:8123", module: "internal", wantStatus: http.StatusForbidden},
+		{name: "path", target: host + "/play", module: "internal", wantStatus: http.StatusBadRequest},
+		{name: "query", target: host + "/?readonly=0", module: "internal", wantStatus: http.StatusBadRequest},
+		{name: "userinfo", target: "http://admin:secret@" + host, module: "internal", wantStatus: http.StatusBadRequest},
+		{name: "fragment", target: host + "#x", module: "open", wantStatus: http.StatusBadRequest},
+		{name: "unknown module", target: host, module: "missing", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := url.Values{"target": {tt.target}, "module": {tt.module}}
+			recorder := httptest.NewRecorder()
+			prober.ServeHTTP(recorder, httptest.NewRequest("GET", "/probe?"+query.Encode(), nil))
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body)
+			}
+			users := fake.takeUsers()
+			if fmt.Sprint(users) != fmt.Sprint(tt.wantUsers) {
+				t.Errorf("clickhouse received users %q, want %q", users, tt.wantUsers)
+			}
+			if tt.wantStatus == http.StatusOK && !strings.Contains(recorder.Body.String(), "clickhouse_query 1") {
+				t.Errorf("got body without the metrics of the target:\n%s", recorder.Body)
+			}
+		})
+	}
+}
+
+func TestProberEvictsHolders(t *testing.T) {
+	prober := newTestProber(t, "open:\n  collectors: [basic_exporter]\n")
+	module := prober.modules["open"]
+
+	for i := 0; i <= maxProbeHolders; i++ {
+		if _, err := prober.holder(fmt.Sprintf("ch-%d:8123", i), "open", module); err != nil {
+			t.Fatal(err)
+		}
+		// probing the first target again keeps it the most recently used
+		if i > 0 {
+			if _, err := prober.holder("ch-0:8123", "open", module); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if len(prober.holders) != maxProbeHolders || prober.holdersLRU.Len() != maxProbeHolders {
+		t.Fatalf("got %d holders and %d in the lru, want %d", len(prober.holders), prober.holdersLRU.Len(), maxProbeHolders)
+	}
+	if _, found := prober.holders["open/ch-1:8123"]; found {
+		t.Error("the least recently probed target was kept")
+	}
+	for _, target := range []string{"ch-0:8123", "ch-2:8123", fmt.Sprintf("ch-%d:8123", maxProbeHolders)} {
+		if _, found := prober.holders["open/"+target]; !found {
+			t.Errorf("%s was evicted", target)
+		}
+	}
+}
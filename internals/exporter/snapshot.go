@@ -0,0 +1,79 @@
+package exporter
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	snapshotAgeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(NAMESPACE, "exporter", "snapshot_age_seconds"),
+		"Age of the served snapshot of clickhouse metrics in seconds.",
+		nil, nil,
+	)
+)
+
+// snapshot holds the metrics of the last background scrape.
+type snapshot struct {
+	metrics []prometheus.Metric
+	time    time.Time
+}
+
+// StartBackgroundScrape makes the holder scrape clickhouse every interval on its
+// own and serve the latest snapshot instead of scraping on every request. Each
+// background scrape gets interval as its deadline so scrapes never overlap.
+func (e *ExporterHolder) StartBackgroundScrape(interval time.Duration) {
+	e.background = true
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			e.refreshSnapshot(interval)
+			<-ticker.C
+		}
+	}()
+}
+
+func (e *ExporterHolder) refreshSnapshot(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	metricCh := make(chan prometheus.Metric)
+	doneCh := make(chan struct{})
+	var metrics []prometheus.Metric
+	go func() {
+		for m := range metricCh {
+			metrics = append(metrics, m)
+		}
+		close(doneCh)
+	}()
+
+	e.collectWithContext(ctx, metricCh)
+	close(metricCh)
+	<-doneCh
+
+	e.snapshotMu.Lock()
+	e.snapshot = &snapshot{metrics: metrics, time: start}
+	e.snapshotMu.Unlock()
+}
+
+// collectSnapshot sends the metrics of the latest background scrape along with
+// its age. Nothing is sent before the first background scrape is done.
+func (e *ExporterHolder) collectSnapshot(ch chan<- prometheus.Metric) {
+	e.snapshotMu.RLock()
+	current := e.snapshot
+	e.snapshotMu.RUnlock()
+
+	if current == nil {
+		return
+	}
+	for _, m := range current.metrics {
+		ch <- m
+	}
+	ch <- prometheus.MustNewConstMetric(snapshotAgeDesc, prometheus.GaugeValue, time.Since(current.time).Seconds())
+}
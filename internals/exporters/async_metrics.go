@@ -1,9 +1,9 @@
 package exporters
 
 import (
+	"context"
 	"fmt"
 	"net/url"
-	"strings"
 
 	"github.com/ClickHouse/clickhouse_exporter/internals/util"
 	"github.com/ClickHouse/clickhouse_exporter/pkg/clickhouse"
@@ -16,18 +16,26 @@ import (
 
 const (
 	ASYNC_METRIC_EXPORTER_QUERY = `
-	select replaceRegexpAll(toString(metric), '-', '_') AS metric, value from system.asynchronous_metrics {FILTER_CLAUSE}`
+	select replaceRegexpAll(toString(metric), '-', '_') AS metric, value {CLUSTER_COLUMNS} from system.asynchronous_metrics {FILTER_CLAUSE}`
 )
 
+func init() {
+	RegisterCollector("async_exporter", func(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) Collector {
+		exporter := NewAsyncMetricsExporter(uri, namespace, yamlconfig, cluster)
+		return &exporter
+	})
+}
+
 type AsyncMetricsExporter struct {
 	Namespace string
 	QueryURI  string
+
+	ClusterLabels util.ClusterLabels
 }
 
-func NewAsyncMetricsExporter(uri url.URL, namespace string, yamlconfig yaml.YamlConfig) AsyncMetricsExporter {
+func NewAsyncMetricsExporter(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) AsyncMetricsExporter {
 
-	filter_calause := queryparser.ParseYamlConfigToQueryFilter(yamlconfig)
-	query := strings.Replace(ASYNC_METRIC_EXPORTER_QUERY, "{FILTER_CLAUSE}", filter_calause, 1)
+	query := queryparser.BuildQuery(ASYNC_METRIC_EXPORTER_QUERY, yamlconfig, cluster)
 	log.Printf("async exporter query: %v", query)
 
 	url_values := uri.Query()
@@ -36,13 +44,14 @@ func NewAsyncMetricsExporter(uri url.URL, namespace string, yamlconfig yaml.Yaml
 	metricsURI.RawQuery = url_values.Encode()
 
 	return AsyncMetricsExporter{
-		QueryURI:  metricsURI.String(),
-		Namespace: namespace,
+		QueryURI:      metricsURI.String(),
+		Namespace:     namespace,
+		ClusterLabels: util.NewClusterLabels(cluster),
 	}
 }
 
-func (e *AsyncMetricsExporter) Scrap(clickConn clickhouse.ClickhouseConn, ch chan<- prometheus.Metric) error {
-	asyncMetrics, err := util.ParseKeyValueResponse(e.QueryURI, clickConn)
+func (e *AsyncMetricsExporter) Scrap(ctx context.Context, clickConn clickhouse.ClickhouseConn, ch chan<- prometheus.Metric) error {
+	asyncMetrics, err := util.ParseKeyValueResponse(ctx, e.QueryURI, clickConn)
 	if err != nil {
 		return fmt.Errorf("error scraping clickhouse url %v: %v", e.QueryURI, err)
 	}
@@ -56,7 +65,7 @@ func (e *AsyncMetricsExporter) collect(resultLines []util.LineResult, ch chan<-
 			Namespace: e.Namespace,
 			Name:      util.GetMetricName(am.Key),
 			Help:      "Number of " + am.Key + " async processed",
-		}, []string{}).WithLabelValues()
+		}, e.ClusterLabels.Names()).WithLabelValues(e.ClusterLabels.Values(am.NodeLabels)...)
 		newMetric.Set(am.Value)
 		newMetric.Collect(ch)
 	}
@@ -0,0 +1,222 @@
+package exporters
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+
+	"github.com/ClickHouse/clickhouse_exporter/internals/util"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/clickhouse"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/queryparser"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/yaml"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// a backup restored later keeps its name, the latest operation wins and
+	// last_success_age is -1 until a backup of the name succeeded
+	BACKUPS_METRIC_EXPORTER_QUERY = `
+	select
+		name,
+		toString(argMax(status, start_time)) as last_status,
+		toUnixTimestamp(max(start_time)) as last_start_time,
+		toUnixTimestamp(argMax(end_time, start_time)) as last_end_time,
+		argMax(total_size, start_time) as last_total_size,
+		argMax(num_files, start_time) as last_num_files,
+		argMax(error, start_time) != '' as last_has_error,
+		if(countIf(status = 'BACKUP_CREATED') = 0, -1,
+			dateDiff('second', maxIf(end_time, status = 'BACKUP_CREATED'), now())) as last_success_age {CLUSTER_COLUMNS}
+	from system.backups
+	{FILTER_CLAUSE}
+	group by name {CLUSTER_GROUP_BY}`
+)
+
+// every status of a backup or restore, exported as a state set
+var backupStatuses = []string{
+	"CREATING_BACKUP",
+	"BACKUP_CREATED",
+	"BACKUP_FAILED",
+	"BACKUP_CANCELLED",
+	"RESTORING",
+	"RESTORED",
+	"RESTORE_FAILED",
+	"RESTORE_CANCELLED",
+}
+
+// The destination of a backup is its name without the backup itself:
+// Disk('backups', 'nightly.zip') is in Disk('backups'), and
+// S3('https://bucket/nightly/2024-01-01', ...) in S3('https://bucket/nightly/').
+var (
+	diskBackupPattern = regexp.MustCompile(`^Disk\('[^']*'`)
+	pathBackupPattern = regexp.MustCompile(`^\w+\('[^']*/`)
+)
+
+func backupDestination(name string) string {
+	if destination := diskBackupPattern.FindString(name); destination != "" {
+		return destination + ")"
+	}
+	if destination := pathBackupPattern.FindString(name); destination != "" {
+		return destination + "')"
+	}
+	return name
+}
+
+func init() {
+	RegisterCollector("backups_exporter", func(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) Collector {
+		exporter := NewBackupsMetricsExporter(uri, namespace, yamlconfig, cluster)
+		return &exporter
+	})
+}
+
+type BackupsMetricsExporter struct {
+	Namespace string
+	QueryURI  string
+
+	ClusterLabels util.ClusterLabels
+}
+
+func NewBackupsMetricsExporter(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) BackupsMetricsExporter {
+
+	query := queryparser.BuildQuery(BACKUPS_METRIC_EXPORTER_QUERY, yamlconfig, cluster)
+	log.Printf("backups exporter query: %v", query)
+
+	url_values := uri.Query()
+	metricsURI := uri
+	url_values.Set("query", query)
+	metricsURI.RawQuery = url_values.Encode()
+
+	return BackupsMetricsExporter{
+		QueryURI:      metricsURI.String(),
+		Namespace:     namespace,
+		ClusterLabels: util.NewClusterLabels(cluster),
+	}
+}
+
+func (e *BackupsMetricsExporter) Scrap(ctx context.Context, clickConn clickhouse.ClickhouseConn, ch chan<- prometheus.Metric) error {
+	backups, err := e.parseResponse(ctx, clickConn)
+	if err != nil {
+		return fmt.Errorf("error scraping clickhouse url %v: %v", e.QueryURI, err)
+	}
+	e.collect(backups, ch)
+	return nil
+}
+
+type BackupsResult struct {
+	Name           string `ch:"name"`
+	Status         string `ch:"last_status"`
+	StartTime      int    `ch:"last_start_time"`
+	EndTime        int    `ch:"last_end_time"`
+	TotalSize      int    `ch:"last_total_size"`
+	NumFiles       int    `ch:"last_num_files"`
+	HasError       int    `ch:"last_has_error"`
+	LastSuccessAge int    `ch:"last_success_age"`
+	util.NodeLabels
+}
+
+func (e *BackupsMetricsExporter) parseResponse(ctx context.Context, clickConn clickhouse.ClickhouseConn) ([]BackupsResult, error) {
+	result, err := clickConn.Query(ctx, e.QueryURI)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []BackupsResult
+	if err := result.Decode(&results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (e *BackupsMetricsExporter) collect(resultLines []BackupsResult, ch chan<- prometheus.Metric) {
+	metric_label := e.ClusterLabels.Names("name")
+
+	type destinationKey struct {
+		destination string
+		node        util.NodeLabels
+	}
+	lastSuccessAges := make(map[destinationKey]int)
+
+	for _, backup := range resultLines {
+		label_values := e.ClusterLabels.Values(backup.NodeLabels, backup.Name)
+
+		statusMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "backup_status",
+			Help:      "Status of the latest backup or restore of the name, 1 for the current one",
+		}, e.ClusterLabels.Names("name", "status"))
+		statuses := backupStatuses
+		if !slices.Contains(statuses, backup.Status) {
+			statuses = append(slices.Clone(statuses), backup.Status)
+		}
+		for _, status := range statuses {
+			value := 0.0
+			if status == backup.Status {
+				value = 1
+			}
+			statusMetric.WithLabelValues(e.ClusterLabels.Values(backup.NodeLabels, backup.Name, status)...).Set(value)
+		}
+		statusMetric.Collect(ch)
+
+		newStartTimeMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "backup_start_timestamp_seconds",
+			Help:      "Unix time the latest backup or restore of the name started",
+		}, metric_label).WithLabelValues(label_values...)
+		newStartTimeMetric.Set(float64(backup.StartTime))
+		newStartTimeMetric.Collect(ch)
+
+		// a running backup has no end yet
+		if backup.EndTime > 0 {
+			newEndTimeMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: e.Namespace,
+				Name:      "backup_end_timestamp_seconds",
+				Help:      "Unix time the latest backup or restore of the name ended",
+			}, metric_label).WithLabelValues(label_values...)
+			newEndTimeMetric.Set(float64(backup.EndTime))
+			newEndTimeMetric.Collect(ch)
+		}
+
+		newTotalSizeMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "backup_total_size_bytes",
+			Help:      "Total size of the files of the backup in bytes",
+		}, metric_label).WithLabelValues(label_values...)
+		newTotalSizeMetric.Set(float64(backup.TotalSize))
+		newTotalSizeMetric.Collect(ch)
+
+		newNumFilesMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "backup_num_files",
+			Help:      "Number of files of the backup",
+		}, metric_label).WithLabelValues(label_values...)
+		newNumFilesMetric.Set(float64(backup.NumFiles))
+		newNumFilesMetric.Collect(ch)
+
+		newHasErrorMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "backup_has_error",
+			Help:      "Did the latest backup or restore of the name end with an error",
+		}, metric_label).WithLabelValues(label_values...)
+		newHasErrorMetric.Set(float64(backup.HasError))
+		newHasErrorMetric.Collect(ch)
+
+		if backup.LastSuccessAge >= 0 {
+			key := destinationKey{destination: backupDestination(backup.Name), node: backup.NodeLabels}
+			if age, found := lastSuccessAges[key]; !found || backup.LastSuccessAge < age {
+				lastSuccessAges[key] = backup.LastSuccessAge
+			}
+		}
+	}
+
+	lastSuccessDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(e.Namespace, "", "backup_last_success_age_seconds"),
+		"Seconds since the last backup to the destination succeeded",
+		e.ClusterLabels.Names("destination"), nil)
+	for key, age := range lastSuccessAges {
+		ch <- prometheus.MustNewConstMetric(lastSuccessDesc, prometheus.GaugeValue, float64(age),
+			e.ClusterLabels.Values(key.node, key.destination)...)
+	}
+}
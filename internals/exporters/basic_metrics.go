@@ -1,9 +1,9 @@
 package exporters
 
 import (
+	"context"
 	"fmt"
 	"net/url"
-	"strings"
 
 	"github.com/ClickHouse/clickhouse_exporter/internals/util"
 	"github.com/ClickHouse/clickhouse_exporter/pkg/clickhouse"
@@ -15,18 +15,26 @@ import (
 )
 
 const (
-	BASIC_METRIC_EXPORTER_QUERY = "select metric, value from system.metrics {FILTER_CLAUSE}"
+	BASIC_METRIC_EXPORTER_QUERY = "select metric, value {CLUSTER_COLUMNS} from system.metrics {FILTER_CLAUSE}"
 )
 
+func init() {
+	RegisterCollector("basic_exporter", func(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) Collector {
+		exporter := NewBasicMetricsExporter(uri, namespace, yamlconfig, cluster)
+		return &exporter
+	})
+}
+
 type BasicMetricsExporter struct {
 	Namespace string
 	QueryURI  string
+
+	ClusterLabels util.ClusterLabels
 }
 
-func NewBasicMetricsExporter(uri url.URL, namespace string, yamlconfig yaml.YamlConfig) BasicMetricsExporter {
+func NewBasicMetricsExporter(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) BasicMetricsExporter {
 
-	filter_calause := queryparser.ParseYamlConfigToQueryFilter(yamlconfig)
-	query := strings.Replace(BASIC_METRIC_EXPORTER_QUERY, "{FILTER_CLAUSE}", filter_calause, 1)
+	query := queryparser.BuildQuery(BASIC_METRIC_EXPORTER_QUERY, yamlconfig, cluster)
 	log.Printf("metrics exporter query: %v", query)
 
 	url_values := uri.Query()
@@ -35,13 +43,14 @@ func NewBasicMetricsExporter(uri url.URL, namespace string, yamlconfig yaml.Yaml
 	metricsURI.RawQuery = url_values.Encode()
 
 	return BasicMetricsExporter{
-		QueryURI:  metricsURI.String(),
-		Namespace: namespace,
+		QueryURI:      metricsURI.String(),
+		Namespace:     namespace,
+		ClusterLabels: util.NewClusterLabels(cluster),
 	}
 }
 
-func (e *BasicMetricsExporter) Scrap(clickConn clickhouse.ClickhouseConn, ch chan<- prometheus.Metric) error {
-	metrics, err := util.ParseKeyValueResponse(e.QueryURI, clickConn)
+func (e *BasicMetricsExporter) Scrap(ctx context.Context, clickConn clickhouse.ClickhouseConn, ch chan<- prometheus.Metric) error {
+	metrics, err := util.ParseKeyValueResponse(ctx, e.QueryURI, clickConn)
 	if err != nil {
 		return fmt.Errorf("error scraping clickhouse url %v: %v", e.QueryURI, err)
 	}
@@ -55,7 +64,7 @@ func (e *BasicMetricsExporter) collect(resultLines []util.LineResult, ch chan<-
 			Namespace: e.Namespace,
 			Name:      util.GetMetricName(am.Key),
 			Help:      "Number of " + am.Key + " async processed",
-		}, []string{}).WithLabelValues()
+		}, e.ClusterLabels.Names()).WithLabelValues(e.ClusterLabels.Values(am.NodeLabels)...)
 		newMetric.Set(am.Value)
 		newMetric.Collect(ch)
 	}
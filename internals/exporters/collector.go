@@ -0,0 +1,91 @@
+package exporters
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+
+	"github.com/ClickHouse/clickhouse_exporter/pkg/clickhouse"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/yaml"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector is implemented by every exporter of this package. Scrap runs the
+// exporter query against clickhouse and sends the resulting metrics to ch, it
+// must give up once ctx is done.
+type Collector interface {
+	Scrap(ctx context.Context, clickConn clickhouse.ClickhouseConn, ch chan<- prometheus.Metric) error
+}
+
+// CollectorFactory builds a Collector from the scrape uri, the metrics namespace,
+// the collector's own section of the query filters yaml and the cluster to scrape
+// through clusterAllReplicas, empty to scrape the node of the uri only.
+type CollectorFactory func(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) Collector
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]CollectorFactory)
+)
+
+// RegisterCollector makes a collector available under the given name. The name
+// is also the key of the collector's section in the query filters yaml.
+// It panics if the name is registered twice.
+func RegisterCollector(name string, factory CollectorFactory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if _, exists := factories[name]; exists {
+		panic(fmt.Sprintf("collector %q is already registered", name))
+	}
+	factories[name] = factory
+}
+
+// CollectorNames returns the names of all registered collectors in sorted order.
+func CollectorNames() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AllCollectorNames returns the names of the registered collectors and of the
+// custom collectors declared in the query filters yaml, in sorted order.
+func AllCollectorNames(queryFilters yaml.YamlConfig) []string {
+	names := CollectorNames()
+
+	factoriesMu.RLock()
+	for name := range queryFilters.GetData() {
+		if _, registered := factories[name]; !registered && IsCustomCollector(queryFilters.GetMapObject(name)) {
+			names = append(names, name)
+		}
+	}
+	factoriesMu.RUnlock()
+
+	sort.Strings(names)
+	return names
+}
+
+// NewCollector builds the collector registered under the given name, or the
+// custom collector declared by yamlconfig.
+func NewCollector(name string, uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) (Collector, error) {
+	factoriesMu.RLock()
+	factory, exists := factories[name]
+	factoriesMu.RUnlock()
+
+	if exists {
+		return factory(uri, namespace, yamlconfig, cluster), nil
+	}
+	if IsCustomCollector(yamlconfig) {
+		exporter := NewCustomMetricsExporter(name, uri, namespace, yamlconfig, cluster)
+		return &exporter, nil
+	}
+	return nil, fmt.Errorf("collector %q is not registered", name)
+}
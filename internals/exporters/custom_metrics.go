@@ -0,0 +1,173 @@
+package exporters
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/ClickHouse/clickhouse_exporter/internals/util"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/clickhouse"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/queryparser"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/yaml"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	customMetricName = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	customLabelName  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// CustomMetricsExporter runs a query declared in the query filters yaml. Each
+// row gives one sample per declared metric, labeled with the label columns:
+//
+//	tenant_rows_exporter:
+//	  query: "select tenant, sum(rows) as rows {CLUSTER_COLUMNS} from db.ingest {FILTER_CLAUSE} group by tenant {CLUSTER_GROUP_BY}"
+//	  labels: [tenant]
+//	  metrics:
+//	    - column: rows
+//	      name: tenant_ingested_rows_total
+//	      type: counter
+//	      help: Rows ingested per tenant
+type CustomMetricsExporter struct {
+	Namespace string
+	QueryURI  string
+	Labels    []string
+	Metrics   []CustomMetric
+
+	ClusterLabels util.ClusterLabels
+}
+
+// CustomMetric turns the values of a column into a metric.
+type CustomMetric struct {
+	Column    string
+	Desc      *prometheus.Desc
+	ValueType prometheus.ValueType
+}
+
+// IsCustomCollector tells whether a section of the query filters yaml declares
+// a collector of its own.
+func IsCustomCollector(yamlconfig yaml.YamlConfig) bool {
+	return yamlconfig.Contains("query")
+}
+
+func NewCustomMetricsExporter(name string, uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) CustomMetricsExporter {
+
+	query := queryparser.BuildQuery(yamlconfig.GetString("query", ""), yamlconfig, cluster)
+	log.Printf("%s query: %v", name, query)
+
+	url_values := uri.Query()
+	metricsURI := uri
+	url_values.Set("query", query)
+	metricsURI.RawQuery = url_values.Encode()
+
+	clusterLabels := util.NewClusterLabels(cluster)
+	labels := yamlconfig.GetStringList("labels")
+	labelNames := make(map[string]bool)
+	for _, label := range clusterLabels.Names(labels...) {
+		if !customLabelName.MatchString(label) || strings.HasPrefix(label, "__") {
+			panic("error: label " + label + " of " + name + " is not a valid prometheus label name, alias the column in the query")
+		}
+		if labelNames[label] {
+			panic("error: label " + label + " of " + name + " is declared twice")
+		}
+		labelNames[label] = true
+	}
+
+	var metrics []CustomMetric
+	for _, metricConfig := range yamlconfig.GetMapList("metrics") {
+		column := metricConfig.GetString("column", "")
+		if column == "" {
+			panic("error: every metric of " + name + " needs a column")
+		}
+		metricName := metricConfig.GetString("name", column)
+		if !customMetricName.MatchString(metricName) {
+			panic("error: metric " + metricName + " of " + name + " is not a valid prometheus metric name, set its name")
+		}
+
+		var valueType prometheus.ValueType
+		switch metricType := metricConfig.GetString("type", "gauge"); metricType {
+		case "gauge":
+			valueType = prometheus.GaugeValue
+		case "counter":
+			valueType = prometheus.CounterValue
+		default:
+			panic("error: type of " + metricName + " in " + name + " must be gauge or counter, not " + metricType)
+		}
+
+		metrics = append(metrics, CustomMetric{
+			Column: column,
+			Desc: prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, "", metricName),
+				metricConfig.GetString("help", column+" of "+name),
+				clusterLabels.Names(labels...), nil,
+			),
+			ValueType: valueType,
+		})
+	}
+	if len(metrics) == 0 {
+		panic("error: " + name + " declares no metrics")
+	}
+
+	return CustomMetricsExporter{
+		QueryURI:      metricsURI.String(),
+		Namespace:     namespace,
+		Labels:        labels,
+		Metrics:       metrics,
+		ClusterLabels: clusterLabels,
+	}
+}
+
+func (e *CustomMetricsExporter) Scrap(ctx context.Context, clickConn clickhouse.ClickhouseConn, ch chan<- prometheus.Metric) error {
+	result, err := clickConn.Query(ctx, e.QueryURI)
+	if err != nil {
+		return fmt.Errorf("error scraping clickhouse url %v: %v", e.QueryURI, err)
+	}
+	if err := e.collect(result, ch); err != nil {
+		return fmt.Errorf("error scraping clickhouse url %v: %v", e.QueryURI, err)
+	}
+	return nil
+}
+
+func (e *CustomMetricsExporter) collect(result *clickhouse.Result, ch chan<- prometheus.Metric) error {
+	labelColumns := make([]int, len(e.Labels))
+	for i, label := range e.Labels {
+		if labelColumns[i] = result.ColumnIndex(label); labelColumns[i] < 0 {
+			return fmt.Errorf("result has no label column %s", label)
+		}
+	}
+	valueColumns := make([]int, len(e.Metrics))
+	for i, metric := range e.Metrics {
+		if valueColumns[i] = result.ColumnIndex(metric.Column); valueColumns[i] < 0 {
+			return fmt.Errorf("result has no metric column %s", metric.Column)
+		}
+	}
+	var nodes []util.NodeLabels
+	if err := result.Decode(&nodes); err != nil {
+		return err
+	}
+
+	for i, row := range result.Rows {
+		labelValues := make([]string, len(labelColumns))
+		for j, column := range labelColumns {
+			labelValues[j] = row[column]
+		}
+		labelValues = e.ClusterLabels.Values(nodes[i], labelValues...)
+
+		for j, metric := range e.Metrics {
+			value, err := util.ParseNumber(row[valueColumns[j]])
+			if err != nil {
+				return fmt.Errorf("column %s: %v", metric.Column, err)
+			}
+			sample, err := prometheus.NewConstMetric(metric.Desc, metric.ValueType, value, labelValues...)
+			if err != nil {
+				return fmt.Errorf("column %s: %v", metric.Column, err)
+			}
+			ch <- sample
+		}
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package exporters
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/ClickHouse/clickhouse_exporter/internals/util"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/clickhouse"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/queryparser"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/yaml"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// last_successful_update_age is -1 for a dictionary never loaded
+	DICTIONARIES_METRIC_EXPORTER_QUERY = `
+	select
+		database, name, toString(status) as status,
+		bytes_allocated, element_count, load_factor,
+		if(toUnixTimestamp(last_successful_update_time) = 0, -1,
+			dateDiff('second', last_successful_update_time, now())) as last_successful_update_age,
+		loading_duration {CLUSTER_COLUMNS}
+	from system.dictionaries
+	{FILTER_CLAUSE}`
+)
+
+// every status of a dictionary, exported as a state set
+var dictionaryStatuses = []string{
+	"NOT_LOADED",
+	"LOADED",
+	"FAILED",
+	"LOADING",
+	"FAILED_AND_RELOADING",
+	"LOADED_AND_RELOADING",
+	"NOT_EXIST",
+}
+
+func init() {
+	RegisterCollector("dictionaries_exporter", func(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) Collector {
+		exporter := NewDictionariesMetricsExporter(uri, namespace, yamlconfig, cluster)
+		return &exporter
+	})
+}
+
+type DictionariesMetricsExporter struct {
+	Namespace string
+	QueryURI  string
+
+	ClusterLabels util.ClusterLabels
+}
+
+func NewDictionariesMetricsExporter(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) DictionariesMetricsExporter {
+
+	query := queryparser.BuildQuery(DICTIONARIES_METRIC_EXPORTER_QUERY, yamlconfig, cluster)
+	log.Printf("dictionaries exporter query: %v", query)
+
+	url_values := uri.Query()
+	metricsURI := uri
+	url_values.Set("query", query)
+	metricsURI.RawQuery = url_values.Encode()
+
+	return DictionariesMetricsExporter{
+		QueryURI:      metricsURI.String(),
+		Namespace:     namespace,
+		ClusterLabels: util.NewClusterLabels(cluster),
+	}
+}
+
+func (e *DictionariesMetricsExporter) Scrap(ctx context.Context, clickConn clickhouse.ClickhouseConn, ch chan<- prometheus.Metric) error {
+	dictionaries, err := e.parseResponse(ctx, clickConn)
+	if err != nil {
+		return fmt.Errorf("error scraping clickhouse url %v: %v", e.QueryURI, err)
+	}
+	e.collect(dictionaries, ch)
+	return nil
+}
+
+type DictionariesResult struct {
+	Database                string  `ch:"database"`
+	Name                    string  `ch:"name"`
+	Status                  string  `ch:"status"`
+	BytesAllocated          int     `ch:"bytes_allocated"`
+	ElementCount            int     `ch:"element_count"`
+	LoadFactor              float64 `ch:"load_factor"`
+	LastSuccessfulUpdateAge int     `ch:"last_successful_update_age"`
+	LoadingDuration         float64 `ch:"loading_duration"`
+	util.NodeLabels
+}
+
+func (e *DictionariesMetricsExporter) parseResponse(ctx context.Context, clickConn clickhouse.ClickhouseConn) ([]DictionariesResult, error) {
+	result, err := clickConn.Query(ctx, e.QueryURI)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []DictionariesResult
+	if err := result.Decode(&results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (e *DictionariesMetricsExporter) collect(resultLines []DictionariesResult, ch chan<- prometheus.Metric) {
+	metric_label := e.ClusterLabels.Names("database", "dictionary")
+
+	for _, dictionary := range resultLines {
+		label_values := e.ClusterLabels.Values(dictionary.NodeLabels, dictionary.Database, dictionary.Name)
+
+		statusMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "dictionary_status",
+			Help:      "Status of the dictionary, 1 for the current one",
+		}, e.ClusterLabels.Names("database", "dictionary", "status"))
+		for _, status := range dictionaryStatuses {
+			value := 0.0
+			if status == dictionary.Status {
+				value = 1
+			}
+			statusMetric.WithLabelValues(e.ClusterLabels.Values(dictionary.NodeLabels, dictionary.Database, dictionary.Name, status)...).Set(value)
+		}
+		statusMetric.Collect(ch)
+
+		newBytesAllocatedMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "dictionary_bytes_allocated",
+			Help:      "Memory allocated for the dictionary in bytes",
+		}, metric_label).WithLabelValues(label_values...)
+		newBytesAllocatedMetric.Set(float64(dictionary.BytesAllocated))
+		newBytesAllocatedMetric.Collect(ch)
+
+		newElementCountMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "dictionary_element_count",
+			Help:      "Number of items stored in the dictionary",
+		}, metric_label).WithLabelValues(label_values...)
+		newElementCountMetric.Set(float64(dictionary.ElementCount))
+		newElementCountMetric.Collect(ch)
+
+		newLoadFactorMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "dictionary_load_factor",
+			Help:      "Percentage filled in the dictionary",
+		}, metric_label).WithLabelValues(label_values...)
+		newLoadFactorMetric.Set(dictionary.LoadFactor)
+		newLoadFactorMetric.Collect(ch)
+
+		newLoadingDurationMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "dictionary_loading_duration_seconds",
+			Help:      "Duration of the last load of the dictionary in seconds",
+		}, metric_label).WithLabelValues(label_values...)
+		newLoadingDurationMetric.Set(dictionary.LoadingDuration)
+		newLoadingDurationMetric.Collect(ch)
+
+		// a dictionary never loaded has no age
+		if dictionary.LastSuccessfulUpdateAge >= 0 {
+			newUpdateAgeMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: e.Namespace,
+				Name:      "dictionary_last_successful_update_age_seconds",
+				Help:      "Seconds since the dictionary was last loaded successfully",
+			}, metric_label).WithLabelValues(label_values...)
+			newUpdateAgeMetric.Set(float64(dictionary.LastSuccessfulUpdateAge))
+			newUpdateAgeMetric.Collect(ch)
+		}
+	}
+
+}
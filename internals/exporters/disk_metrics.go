@@ -1,9 +1,9 @@
 package exporters
 
 import (
+	"context"
 	"fmt"
 	"net/url"
-	"strings"
 
 	"github.com/ClickHouse/clickhouse_exporter/internals/util"
 	"github.com/ClickHouse/clickhouse_exporter/pkg/clickhouse"
@@ -16,18 +16,26 @@ import (
 
 const (
 	DISK_METRIC_EXPORTER_QUERY = `
-	select name, sum(free_space) as free_space_in_bytes, sum(total_space) as total_space_in_bytes from system.disks {FILTER_CLAUSE} group by name`
+	select name, sum(free_space) as free_space_in_bytes, sum(total_space) as total_space_in_bytes {CLUSTER_COLUMNS} from system.disks {FILTER_CLAUSE} group by name {CLUSTER_GROUP_BY}`
 )
 
+func init() {
+	RegisterCollector("disk_exporter", func(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) Collector {
+		exporter := NewDiskMetricsExporter(uri, namespace, yamlconfig, cluster)
+		return &exporter
+	})
+}
+
 type DiskMetricsExporter struct {
 	Namespace string
 	QueryURI  string
+
+	ClusterLabels util.ClusterLabels
 }
 
-func NewDiskMetricsExporter(uri url.URL, namespace string, yamlconfig yaml.YamlConfig) DiskMetricsExporter {
+func NewDiskMetricsExporter(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) DiskMetricsExporter {
 
-	filter_calause := queryparser.ParseYamlConfigToQueryFilter(yamlconfig)
-	query := strings.Replace(DISK_METRIC_EXPORTER_QUERY, "{FILTER_CLAUSE}", filter_calause, 1)
+	query := queryparser.BuildQuery(DISK_METRIC_EXPORTER_QUERY, yamlconfig, cluster)
 	log.Printf("disk exporter query: %v", query)
 
 	url_values := uri.Query()
@@ -36,13 +44,14 @@ func NewDiskMetricsExporter(uri url.URL, namespace string, yamlconfig yaml.YamlC
 	metricsURI.RawQuery = url_values.Encode()
 
 	return DiskMetricsExporter{
-		QueryURI:  metricsURI.String(),
-		Namespace: namespace,
+		QueryURI:      metricsURI.String(),
+		Namespace:     namespace,
+		ClusterLabels: util.NewClusterLabels(cluster),
 	}
 }
 
-func (e *DiskMetricsExporter) Scrap(clickConn clickhouse.ClickhouseConn, ch chan<- prometheus.Metric) error {
-	disksMetrics, err := e.parseResponse(clickConn)
+func (e *DiskMetricsExporter) Scrap(ctx context.Context, clickConn clickhouse.ClickhouseConn, ch chan<- prometheus.Metric) error {
+	disksMetrics, err := e.parseResponse(ctx, clickConn)
 	if err != nil {
 		return fmt.Errorf("error scraping clickhouse url %v: %v", e.QueryURI, err)
 	}
@@ -51,43 +60,21 @@ func (e *DiskMetricsExporter) Scrap(clickConn clickhouse.ClickhouseConn, ch chan
 }
 
 type diskResult struct {
-	disk       string
-	freeSpace  float64
-	totalSpace float64
+	Disk       string  `ch:"name"`
+	FreeSpace  float64 `ch:"free_space_in_bytes"`
+	TotalSpace float64 `ch:"total_space_in_bytes"`
+	util.NodeLabels
 }
 
-func (e *DiskMetricsExporter) parseResponse(clickConn clickhouse.ClickhouseConn) ([]diskResult, error) {
-	data, err := clickConn.ExcecuteQuery(e.QueryURI)
+func (e *DiskMetricsExporter) parseResponse(ctx context.Context, clickConn clickhouse.ClickhouseConn) ([]diskResult, error) {
+	result, err := clickConn.Query(ctx, e.QueryURI)
 	if err != nil {
 		return nil, err
 	}
 
-	// Parsing results
-	lines := strings.Split(string(data), "\n")
-	var results = make([]diskResult, 0)
-
-	for i, line := range lines {
-		parts := strings.Fields(line)
-		if len(parts) == 0 {
-			continue
-		}
-		if len(parts) != 3 {
-			return nil, fmt.Errorf("parseDiskResponse: unexpected %d line: %s", i, line)
-		}
-		disk := strings.TrimSpace(parts[0])
-
-		freeSpace, err := util.ParseNumber(strings.TrimSpace(parts[1]))
-		if err != nil {
-			return nil, err
-		}
-
-		totalSpace, err := util.ParseNumber(strings.TrimSpace(parts[2]))
-		if err != nil {
-			return nil, err
-		}
-
-		results = append(results, diskResult{disk, freeSpace, totalSpace})
-
+	var results []diskResult
+	if err := result.Decode(&results); err != nil {
+		return nil, err
 	}
 	return results, nil
 }
@@ -98,16 +85,16 @@ func (e *DiskMetricsExporter) collect(resultLines []diskResult, ch chan<- promet
 			Namespace: e.Namespace,
 			Name:      "free_space_in_bytes",
 			Help:      "Disks free_space_in_bytes capacity",
-		}, []string{"disk"}).WithLabelValues(dm.disk)
-		newFreeSpaceMetric.Set(dm.freeSpace)
+		}, e.ClusterLabels.Names("disk")).WithLabelValues(e.ClusterLabels.Values(dm.NodeLabels, dm.Disk)...)
+		newFreeSpaceMetric.Set(dm.FreeSpace)
 		newFreeSpaceMetric.Collect(ch)
 
 		newTotalSpaceMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: e.Namespace,
 			Name:      "total_space_in_bytes",
 			Help:      "Disks total_space_in_bytes capacity",
-		}, []string{"disk"}).WithLabelValues(dm.disk)
-		newTotalSpaceMetric.Set(dm.totalSpace)
+		}, e.ClusterLabels.Names("disk")).WithLabelValues(e.ClusterLabels.Values(dm.NodeLabels, dm.Disk)...)
+		newTotalSpaceMetric.Set(dm.TotalSpace)
 		newTotalSpaceMetric.Collect(ch)
 	}
 }
@@ -0,0 +1,139 @@
+package exporters
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/ClickHouse/clickhouse_exporter/internals/util"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/clickhouse"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/queryparser"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/yaml"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	DISTRIBUTION_QUEUE_METRIC_EXPORTER_QUERY = `
+	select
+		database, table, data_path,
+		data_files, data_compressed_bytes,
+		broken_data_files, error_count, is_blocked {CLUSTER_COLUMNS}
+	from system.distribution_queue
+	{FILTER_CLAUSE}`
+)
+
+func init() {
+	RegisterCollector("distribution_queue_exporter", func(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) Collector {
+		exporter := NewDistributionQueueMetricsExporter(uri, namespace, yamlconfig, cluster)
+		return &exporter
+	})
+}
+
+type DistributionQueueMetricsExporter struct {
+	Namespace string
+	QueryURI  string
+
+	ClusterLabels util.ClusterLabels
+}
+
+func NewDistributionQueueMetricsExporter(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) DistributionQueueMetricsExporter {
+
+	query := queryparser.BuildQuery(DISTRIBUTION_QUEUE_METRIC_EXPORTER_QUERY, yamlconfig, cluster)
+	log.Printf("distribution queue exporter query: %v", query)
+
+	url_values := uri.Query()
+	metricsURI := uri
+	url_values.Set("query", query)
+	metricsURI.RawQuery = url_values.Encode()
+
+	return DistributionQueueMetricsExporter{
+		QueryURI:      metricsURI.String(),
+		Namespace:     namespace,
+		ClusterLabels: util.NewClusterLabels(cluster),
+	}
+}
+
+func (e *DistributionQueueMetricsExporter) Scrap(ctx context.Context, clickConn clickhouse.ClickhouseConn, ch chan<- prometheus.Metric) error {
+	queues, err := e.parseResponse(ctx, clickConn)
+	if err != nil {
+		return fmt.Errorf("error scraping clickhouse url %v: %v", e.QueryURI, err)
+	}
+	e.collect(queues, ch)
+	return nil
+}
+
+type DistributionQueueResult struct {
+	Database            string `ch:"database"`
+	Table               string `ch:"table"`
+	DataPath            string `ch:"data_path"`
+	DataFiles           int    `ch:"data_files"`
+	DataCompressedBytes int    `ch:"data_compressed_bytes"`
+	BrokenDataFiles     int    `ch:"broken_data_files"`
+	ErrorCount          int    `ch:"error_count"`
+	IsBlocked           int    `ch:"is_blocked"`
+	util.NodeLabels
+}
+
+func (e *DistributionQueueMetricsExporter) parseResponse(ctx context.Context, clickConn clickhouse.ClickhouseConn) ([]DistributionQueueResult, error) {
+	result, err := clickConn.Query(ctx, e.QueryURI)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []DistributionQueueResult
+	if err := result.Decode(&results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (e *DistributionQueueMetricsExporter) collect(resultLines []DistributionQueueResult, ch chan<- prometheus.Metric) {
+	metric_label := e.ClusterLabels.Names("database", "table", "data_path")
+
+	for _, queue := range resultLines {
+		label_values := e.ClusterLabels.Values(queue.NodeLabels, queue.Database, queue.Table, queue.DataPath)
+
+		newDataFilesMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "distribution_queue_data_files",
+			Help:      "Number of files waiting to be sent to the shard",
+		}, metric_label).WithLabelValues(label_values...)
+		newDataFilesMetric.Set(float64(queue.DataFiles))
+		newDataFilesMetric.Collect(ch)
+
+		newDataBytesMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "distribution_queue_data_compressed_bytes",
+			Help:      "Compressed size of the files waiting to be sent to the shard in bytes",
+		}, metric_label).WithLabelValues(label_values...)
+		newDataBytesMetric.Set(float64(queue.DataCompressedBytes))
+		newDataBytesMetric.Collect(ch)
+
+		newBrokenFilesMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "distribution_queue_broken_data_files",
+			Help:      "Number of files marked as broken because of an error",
+		}, metric_label).WithLabelValues(label_values...)
+		newBrokenFilesMetric.Set(float64(queue.BrokenDataFiles))
+		newBrokenFilesMetric.Collect(ch)
+
+		newErrorCountMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "distribution_queue_error_count",
+			Help:      "Number of errors sending to the shard",
+		}, metric_label).WithLabelValues(label_values...)
+		newErrorCountMetric.Set(float64(queue.ErrorCount))
+		newErrorCountMetric.Collect(ch)
+
+		newIsBlockedMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "distribution_queue_is_blocked",
+			Help:      "Is sending to the shard blocked",
+		}, metric_label).WithLabelValues(label_values...)
+		newIsBlockedMetric.Set(float64(queue.IsBlocked))
+		newIsBlockedMetric.Collect(ch)
+	}
+
+}
@@ -0,0 +1,104 @@
+package exporters
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/ClickHouse/clickhouse_exporter/internals/util"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/clickhouse"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/queryparser"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/yaml"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	ERRORS_METRIC_EXPORTER_QUERY = `
+	select
+		name, toString(code) as code, toString(remote) as remote, value,
+		toUnixTimestamp(last_error_time) as last_error_time {CLUSTER_COLUMNS}
+	from system.errors
+	{FILTER_CLAUSE}`
+)
+
+func init() {
+	RegisterCollector("errors_exporter", func(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) Collector {
+		exporter := NewErrorsMetricsExporter(uri, namespace, yamlconfig, cluster)
+		return &exporter
+	})
+}
+
+type ErrorsMetricsExporter struct {
+	Namespace string
+	QueryURI  string
+
+	ClusterLabels util.ClusterLabels
+}
+
+func NewErrorsMetricsExporter(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) ErrorsMetricsExporter {
+
+	query := queryparser.BuildQuery(ERRORS_METRIC_EXPORTER_QUERY, yamlconfig, cluster)
+	log.Printf("errors exporter query: %v", query)
+
+	url_values := uri.Query()
+	metricsURI := uri
+	url_values.Set("query", query)
+	metricsURI.RawQuery = url_values.Encode()
+
+	return ErrorsMetricsExporter{
+		QueryURI:      metricsURI.String(),
+		Namespace:     namespace,
+		ClusterLabels: util.NewClusterLabels(cluster),
+	}
+}
+
+func (e *ErrorsMetricsExporter) Scrap(ctx context.Context, clickConn clickhouse.ClickhouseConn, ch chan<- prometheus.Metric) error {
+	error_lines, err := e.parseResponse(ctx, clickConn)
+	if err != nil {
+		return fmt.Errorf("error scraping clickhouse url %v: %v", e.QueryURI, err)
+	}
+	e.collect(error_lines, ch)
+	return nil
+}
+
+type ErrorsResult struct {
+	Name          string  `ch:"name"`
+	Code          string  `ch:"code"`
+	Remote        string  `ch:"remote"`
+	Value         float64 `ch:"value"`
+	LastErrorTime float64 `ch:"last_error_time"`
+	util.NodeLabels
+}
+
+func (e *ErrorsMetricsExporter) parseResponse(ctx context.Context, clickConn clickhouse.ClickhouseConn) ([]ErrorsResult, error) {
+	result, err := clickConn.Query(ctx, e.QueryURI)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []ErrorsResult
+	if err := result.Decode(&results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (e *ErrorsMetricsExporter) collect(resultLines []ErrorsResult, ch chan<- prometheus.Metric) {
+	errorsDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(e.Namespace, "", "errors_total"),
+		"Number of times the error happened since the server started",
+		e.ClusterLabels.Names("name", "code", "remote"), nil)
+	lastErrorDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(e.Namespace, "", "errors_last_error_timestamp_seconds"),
+		"Unix time of the last time the error happened",
+		e.ClusterLabels.Names("name", "code", "remote"), nil)
+
+	for _, clickhouseError := range resultLines {
+		label_values := e.ClusterLabels.Values(clickhouseError.NodeLabels, clickhouseError.Name, clickhouseError.Code, clickhouseError.Remote)
+
+		ch <- prometheus.MustNewConstMetric(errorsDesc, prometheus.CounterValue, clickhouseError.Value, label_values...)
+		ch <- prometheus.MustNewConstMetric(lastErrorDesc, prometheus.GaugeValue, clickhouseError.LastErrorTime, label_values...)
+	}
+}
@@ -1,9 +1,9 @@
 package exporters
 
 import (
+	"context"
 	"fmt"
 	"net/url"
-	"strings"
 
 	"github.com/ClickHouse/clickhouse_exporter/internals/util"
 	"github.com/ClickHouse/clickhouse_exporter/pkg/clickhouse"
@@ -15,18 +15,26 @@ import (
 )
 
 const (
-	EVENT_METRIC_EXPORTER_QUERY = `select event, value from system.events {FILTER_CLAUSE}`
+	EVENT_METRIC_EXPORTER_QUERY = `select event, value {CLUSTER_COLUMNS} from system.events {FILTER_CLAUSE}`
 )
 
+func init() {
+	RegisterCollector("event_exporter", func(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) Collector {
+		exporter := NewEventMetricsExporter(uri, namespace, yamlconfig, cluster)
+		return &exporter
+	})
+}
+
 type EventMetricsExporter struct {
 	Namespace string
 	QueryURI  string
+
+	ClusterLabels util.ClusterLabels
 }
 
-func NewEventMetricsExporter(uri url.URL, namespace string, yamlconfig yaml.YamlConfig) EventMetricsExporter {
+func NewEventMetricsExporter(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) EventMetricsExporter {
 
-	filter_calause := queryparser.ParseYamlConfigToQueryFilter(yamlconfig)
-	query := strings.Replace(EVENT_METRIC_EXPORTER_QUERY, "{FILTER_CLAUSE}", filter_calause, 1)
+	query := queryparser.BuildQuery(EVENT_METRIC_EXPORTER_QUERY, yamlconfig, cluster)
 	log.Printf("events exporter query: %v", query)
 
 	url_values := uri.Query()
@@ -35,13 +43,14 @@ func NewEventMetricsExporter(uri url.URL, namespace string, yamlconfig yaml.Yaml
 	metricsURI.RawQuery = url_values.Encode()
 
 	return EventMetricsExporter{
-		QueryURI:  metricsURI.String(),
-		Namespace: namespace,
+		QueryURI:      metricsURI.String(),
+		Namespace:     namespace,
+		ClusterLabels: util.NewClusterLabels(cluster),
 	}
 }
 
-func (e *EventMetricsExporter) Scrap(clickConn clickhouse.ClickhouseConn, ch chan<- prometheus.Metric) error {
-	events, err := util.ParseKeyValueResponse(e.QueryURI, clickConn)
+func (e *EventMetricsExporter) Scrap(ctx context.Context, clickConn clickhouse.ClickhouseConn, ch chan<- prometheus.Metric) error {
+	events, err := util.ParseKeyValueResponse(ctx, e.QueryURI, clickConn)
 	if err != nil {
 		return fmt.Errorf("error scraping clickhouse url %v: %v", e.QueryURI, err)
 	}
@@ -54,8 +63,8 @@ func (e *EventMetricsExporter) collect(resultLines []util.LineResult, ch chan<-
 		newMetric, _ := prometheus.NewConstMetric(
 			prometheus.NewDesc(
 				e.Namespace+"_"+util.GetMetricName(ev.Key)+"_total",
-				"Number of "+ev.Key+" total processed", []string{}, nil),
-			prometheus.CounterValue, float64(ev.Value))
+				"Number of "+ev.Key+" total processed", e.ClusterLabels.Names(), nil),
+			prometheus.CounterValue, float64(ev.Value), e.ClusterLabels.Values(ev.NodeLabels)...)
 		ch <- newMetric
 	}
 }
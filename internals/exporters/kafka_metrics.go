@@ -0,0 +1,140 @@
+package exporters
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/ClickHouse/clickhouse_exporter/internals/util"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/clickhouse"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/queryparser"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/yaml"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// the ages are -1 when the consumer never polled or committed, and the
+	// server only keeps the last exceptions of each consumer
+	KAFKA_METRIC_EXPORTER_QUERY = `
+	select
+		database, table, consumer_id,
+		length(assignments.topic) as assignments,
+		num_messages_read,
+		if(toUnixTimestamp(last_poll_time) = 0, -1, dateDiff('second', last_poll_time, now())) as last_poll_age,
+		num_commits,
+		if(toUnixTimestamp(last_commit_time) = 0, -1, dateDiff('second', last_commit_time, now())) as last_commit_age,
+		num_rebalance_assignments, num_rebalance_revocations,
+		length(exceptions.text) as exceptions,
+		is_currently_used {CLUSTER_COLUMNS}
+	from system.kafka_consumers
+	{FILTER_CLAUSE}`
+)
+
+func init() {
+	RegisterCollector("kafka_exporter", func(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) Collector {
+		exporter := NewKafkaMetricsExporter(uri, namespace, yamlconfig, cluster)
+		return &exporter
+	})
+}
+
+type KafkaMetricsExporter struct {
+	Namespace string
+	QueryURI  string
+
+	ClusterLabels util.ClusterLabels
+}
+
+func NewKafkaMetricsExporter(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) KafkaMetricsExporter {
+
+	query := queryparser.BuildQuery(KAFKA_METRIC_EXPORTER_QUERY, yamlconfig, cluster)
+	log.Printf("kafka exporter query: %v", query)
+
+	url_values := uri.Query()
+	metricsURI := uri
+	url_values.Set("query", query)
+	metricsURI.RawQuery = url_values.Encode()
+
+	return KafkaMetricsExporter{
+		QueryURI:      metricsURI.String(),
+		Namespace:     namespace,
+		ClusterLabels: util.NewClusterLabels(cluster),
+	}
+}
+
+func (e *KafkaMetricsExporter) Scrap(ctx context.Context, clickConn clickhouse.ClickhouseConn, ch chan<- prometheus.Metric) error {
+	consumers, err := e.parseResponse(ctx, clickConn)
+	if err != nil {
+		return fmt.Errorf("error scraping clickhouse url %v: %v", e.QueryURI, err)
+	}
+	e.collect(consumers, ch)
+	return nil
+}
+
+type KafkaConsumerResult struct {
+	Database             string  `ch:"database"`
+	Table                string  `ch:"table"`
+	ConsumerID           string  `ch:"consumer_id"`
+	Assignments          int     `ch:"assignments"`
+	MessagesRead         float64 `ch:"num_messages_read"`
+	LastPollAge          int     `ch:"last_poll_age"`
+	Commits              float64 `ch:"num_commits"`
+	LastCommitAge        int     `ch:"last_commit_age"`
+	RebalanceAssignments float64 `ch:"num_rebalance_assignments"`
+	RebalanceRevocations float64 `ch:"num_rebalance_revocations"`
+	Exceptions           int     `ch:"exceptions"`
+	IsCurrentlyUsed      int     `ch:"is_currently_used"`
+	util.NodeLabels
+}
+
+func (e *KafkaMetricsExporter) parseResponse(ctx context.Context, clickConn clickhouse.ClickhouseConn) ([]KafkaConsumerResult, error) {
+	result, err := clickConn.Query(ctx, e.QueryURI)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []KafkaConsumerResult
+	if err := result.Decode(&results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (e *KafkaMetricsExporter) desc(name string, help string) *prometheus.Desc {
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(e.Namespace, "kafka_consumer", name), help,
+		e.ClusterLabels.Names("database", "table", "consumer"), nil)
+}
+
+func (e *KafkaMetricsExporter) collect(resultLines []KafkaConsumerResult, ch chan<- prometheus.Metric) {
+	assignmentsDesc := e.desc("assignments", "Number of topic partitions assigned to the consumer")
+	messagesReadDesc := e.desc("messages_read_total", "Number of messages read by the consumer")
+	lastPollAgeDesc := e.desc("last_poll_age_seconds", "Seconds since the consumer last polled")
+	commitsDesc := e.desc("commits_total", "Number of offset commits of the consumer")
+	lastCommitAgeDesc := e.desc("last_commit_age_seconds", "Seconds since the consumer last committed its offsets")
+	rebalanceAssignmentsDesc := e.desc("rebalance_assignments_total", "Number of rebalances assigning partitions to the consumer")
+	rebalanceRevocationsDesc := e.desc("rebalance_revocations_total", "Number of rebalances revoking partitions from the consumer")
+	exceptionsDesc := e.desc("exceptions", "Number of recent exceptions of the consumer kept by the server")
+	isCurrentlyUsedDesc := e.desc("is_currently_used", "Is the consumer in use")
+
+	for _, consumer := range resultLines {
+		label_values := e.ClusterLabels.Values(consumer.NodeLabels, consumer.Database, consumer.Table, consumer.ConsumerID)
+
+		ch <- prometheus.MustNewConstMetric(assignmentsDesc, prometheus.GaugeValue, float64(consumer.Assignments), label_values...)
+		ch <- prometheus.MustNewConstMetric(messagesReadDesc, prometheus.CounterValue, consumer.MessagesRead, label_values...)
+		ch <- prometheus.MustNewConstMetric(commitsDesc, prometheus.CounterValue, consumer.Commits, label_values...)
+		ch <- prometheus.MustNewConstMetric(rebalanceAssignmentsDesc, prometheus.CounterValue, consumer.RebalanceAssignments, label_values...)
+		ch <- prometheus.MustNewConstMetric(rebalanceRevocationsDesc, prometheus.CounterValue, consumer.RebalanceRevocations, label_values...)
+		ch <- prometheus.MustNewConstMetric(exceptionsDesc, prometheus.GaugeValue, float64(consumer.Exceptions), label_values...)
+		ch <- prometheus.MustNewConstMetric(isCurrentlyUsedDesc, prometheus.GaugeValue, float64(consumer.IsCurrentlyUsed), label_values...)
+
+		// a consumer which never polled or committed has no age
+		if consumer.LastPollAge >= 0 {
+			ch <- prometheus.MustNewConstMetric(lastPollAgeDesc, prometheus.GaugeValue, float64(consumer.LastPollAge), label_values...)
+		}
+		if consumer.LastCommitAge >= 0 {
+			ch <- prometheus.MustNewConstMetric(lastCommitAgeDesc, prometheus.GaugeValue, float64(consumer.LastCommitAge), label_values...)
+		}
+	}
+}
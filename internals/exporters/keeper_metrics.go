@@ -0,0 +1,162 @@
+package exporters
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ClickHouse/clickhouse_exporter/pkg/clickhouse"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/keeper"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/yaml"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// subsystem of every metric of the collector. system.metrics and
+// system.asynchronous_metrics hold Keeper* entries, e.g. KeeperOutstandingRequests,
+// which the other collectors export as clickhouse_keeper_*, the values of mntr
+// must not collide with them.
+const keeperSubsystem = "keeper_mntr"
+
+// states a keeper reports in zk_server_state, exported as a state set
+var keeperServerStates = []string{"leader", "follower", "observer", "standalone"}
+
+var keeperMetricKey = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// keys of mntr counting since the keeper started, the others are gauges
+var keeperMonitorCounters = map[string]bool{
+	"zk_packets_received": true,
+	"zk_packets_sent":     true,
+}
+
+func init() {
+	RegisterCollector("keeper_exporter", func(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) Collector {
+		exporter := NewKeeperMetricsExporter(namespace, yamlconfig)
+		return &exporter
+	})
+}
+
+// KeeperMetricsExporter talks to ClickHouse Keeper or ZooKeeper directly with
+// four letter word commands, it does not query clickhouse. A target which does
+// not answer gets clickhouse_keeper_mntr_up 0 and does not fail the collector.
+type KeeperMetricsExporter struct {
+	Namespace string
+	Targets   []string
+}
+
+func NewKeeperMetricsExporter(namespace string, yamlconfig yaml.YamlConfig) KeeperMetricsExporter {
+	targets := yamlconfig.GetStringList("targets")
+	log.Printf("keeper exporter targets: %v", targets)
+
+	return KeeperMetricsExporter{
+		Namespace: namespace,
+		Targets:   targets,
+	}
+}
+
+func (e *KeeperMetricsExporter) Scrap(ctx context.Context, clickConn clickhouse.ClickhouseConn, ch chan<- prometheus.Metric) error {
+	var wg sync.WaitGroup
+	for _, target := range e.Targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.scrapTarget(ctx, target, ch)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+func (e *KeeperMetricsExporter) scrapTarget(ctx context.Context, target string, ch chan<- prometheus.Metric) {
+	up := 0.0
+	defer func() {
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(prometheus.BuildFQName(e.Namespace, keeperSubsystem, "up"),
+				"Did the keeper answer imok to ruok", []string{"keeper"}, nil),
+			prometheus.GaugeValue, up, target)
+	}()
+
+	answer, err := keeper.SendCommand(ctx, target, keeper.CommandHealth)
+	if err != nil {
+		log.Error().Msgf("Error scraping keeper %s: %s", target, err)
+		return
+	}
+	if strings.TrimSpace(answer) == keeper.HealthyAnswer {
+		up = 1
+	}
+
+	answer, err = keeper.SendCommand(ctx, target, keeper.CommandMonitor)
+	if err != nil {
+		log.Error().Msgf("Error scraping keeper %s: %s", target, err)
+		return
+	}
+	e.collectMonitor(target, keeper.ParseMonitor(answer), ch)
+
+	answer, err = keeper.SendCommand(ctx, target, keeper.CommandServer)
+	if err != nil {
+		log.Error().Msgf("Error scraping keeper %s: %s", target, err)
+		return
+	}
+	e.collectServer(target, keeper.ParseServer(answer), ch)
+}
+
+// collectMonitor exports every numeric value of mntr under its name without
+// the zk_ prefix, clickhouse_keeper_mntr_znode_count for zk_znode_count.
+func (e *KeeperMetricsExporter) collectMonitor(target string, values map[string]string, ch chan<- prometheus.Metric) {
+	for key, value := range values {
+		if key == "zk_server_state" {
+			stateMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: e.Namespace,
+				Subsystem: keeperSubsystem,
+				Name:      "server_state",
+				Help:      "State of the keeper in its ensemble, 1 for the current one",
+			}, []string{"keeper", "state"})
+			for _, state := range keeperServerStates {
+				stateValue := 0.0
+				if state == value {
+					stateValue = 1
+				}
+				stateMetric.WithLabelValues(target, state).Set(stateValue)
+			}
+			stateMetric.Collect(ch)
+			continue
+		}
+
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil || !keeperMetricKey.MatchString(key) {
+			continue
+		}
+		name := strings.TrimPrefix(key, "zk_")
+		valueType := prometheus.GaugeValue
+		if keeperMonitorCounters[key] {
+			name += "_total"
+			valueType = prometheus.CounterValue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(prometheus.BuildFQName(e.Namespace, keeperSubsystem, name),
+				"Value of "+key+" in the mntr answer of the keeper", []string{"keeper"}, nil),
+			valueType, number, target)
+	}
+}
+
+// collectServer exports what srvr adds to mntr, the last zxid and the version.
+// ZooKeeper prints the zxid in hexadecimal with a 0x prefix, some versions of
+// ClickHouse Keeper in decimal.
+func (e *KeeperMetricsExporter) collectServer(target string, values map[string]string, ch chan<- prometheus.Metric) {
+	if zxid, err := strconv.ParseUint(values["Zxid"], 0, 64); err == nil {
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(prometheus.BuildFQName(e.Namespace, keeperSubsystem, "zxid"),
+				"Last transaction id of the keeper", []string{"keeper"}, nil),
+			prometheus.GaugeValue, float64(zxid), target)
+	}
+	if version, found := values["version"]; found {
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(prometheus.BuildFQName(e.Namespace, keeperSubsystem, "info"),
+				"Version of the keeper", []string{"keeper", "version"}, nil),
+			prometheus.GaugeValue, 1, target, version)
+	}
+}
@@ -0,0 +1,197 @@
+package exporters
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ClickHouse/clickhouse_exporter/pkg/clickhouse"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/yaml"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// listenKeeper starts a keeper answering four letter words with answers, and
+// returns its address.
+func listenKeeper(t *testing.T, answers map[string]string) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer conn.Close()
+				command := make([]byte, 4)
+				if _, err := io.ReadFull(conn, command); err != nil {
+					return
+				}
+				conn.Write([]byte(answers[string(command)]))
+			}()
+		}
+	}()
+	t.Cleanup(func() {
+		listener.Close()
+		wg.Wait()
+	})
+	return listener.Addr().String()
+}
+
+func TestKeeperMetrics(t *testing.T) {
+	leader := listenKeeper(t, map[string]string{
+		"ruok": "imok",
+		"mntr": "zk_version\tv24.8.1.1-stable-abc\n" +
+			"zk_avg_latency\t1\n" +
+			"zk_packets_received\t120\n" +
+			"zk_server_state\tleader\n" +
+			"zk_bad-key\t3\n" +
+			"zk_followers\t2\n",
+		"srvr": "ClickHouse Keeper version: v24.8.1.1-stable-abc\nZxid: 26\nMode: leader\n",
+	})
+	follower := listenKeeper(t, map[string]string{
+		"ruok": "This ZooKeeper instance is not currently serving requests\n",
+		"mntr": "zk_server_state\tfollower\nzk_avg_latency\t4\n",
+		"srvr": "Zookeeper version: 3.8.4-9316c2a, built on 2024-02-12 22:16 UTC\nZxid: 0x1a\nMode: follower\n",
+	})
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachable := closed.Addr().String()
+	closed.Close()
+
+	config := readYamlString(t, "keeper_exporter:\n  targets:\n    - "+leader+"\n    - "+follower+"\n    - "+unreachable+"\n")
+	e := NewKeeperMetricsExporter("clickhouse", config.GetMapObject("keeper_exporter"))
+
+	values, err := collectSamples(t, func(ch chan<- prometheus.Metric) error {
+		return e.Scrap(context.Background(), clickhouse.ClickhouseConn{}, ch)
+	})
+	if err != nil {
+		t.Fatalf("an unreachable keeper failed the collector: %v", err)
+	}
+
+	want := map[string]float64{
+		`clickhouse_keeper_mntr_up{keeper="` + leader + `"}`:                                                            1,
+		`clickhouse_keeper_mntr_up{keeper="` + follower + `"}`:                                                          0,
+		`clickhouse_keeper_mntr_up{keeper="` + unreachable + `"}`:                                                       0,
+		`clickhouse_keeper_mntr_server_state{keeper="` + leader + `",state="leader"}`:                                   1,
+		`clickhouse_keeper_mntr_server_state{keeper="` + leader + `",state="follower"}`:                                 0,
+		`clickhouse_keeper_mntr_server_state{keeper="` + leader + `",state="observer"}`:                                 0,
+		`clickhouse_keeper_mntr_server_state{keeper="` + leader + `",state="standalone"}`:                               0,
+		`clickhouse_keeper_mntr_server_state{keeper="` + follower + `",state="leader"}`:                                 0,
+		`clickhouse_keeper_mntr_server_state{keeper="` + follower + `",state="follower"}`:                               1,
+		`clickhouse_keeper_mntr_server_state{keeper="` + follower + `",state="observer"}`:                               0,
+		`clickhouse_keeper_mntr_server_state{keeper="` + follower + `",state="standalone"}`:                             0,
+		`clickhouse_keeper_mntr_avg_latency{keeper="` + leader + `"}`:                                                   1,
+		`clickhouse_keeper_mntr_avg_latency{keeper="` + follower + `"}`:                                                 4,
+		`clickhouse_keeper_mntr_packets_received_total{keeper="` + leader + `"}`:                                        120,
+		`clickhouse_keeper_mntr_followers{keeper="` + leader + `"}`:                                                     2,
+		`clickhouse_keeper_mntr_zxid{keeper="` + leader + `"}`:                                                          26,
+		`clickhouse_keeper_mntr_zxid{keeper="` + follower + `"}`:                                                        26,
+		`clickhouse_keeper_mntr_info{keeper="` + leader + `",version="v24.8.1.1-stable-abc"}`:                           1,
+		`clickhouse_keeper_mntr_info{keeper="` + follower + `",version="3.8.4-9316c2a, built on 2024-02-12 22:16 UTC"}`: 1,
+	}
+	for key, value := range want {
+		got, found := values[key]
+		if !found {
+			t.Errorf("missing %s", key)
+		} else if got != value {
+			t.Errorf("%s = %v, want %v", key, got, value)
+		}
+	}
+	// zk_version is not a number and zk_bad-key not a metric name, both are left out
+	for key := range values {
+		if _, found := want[key]; !found {
+			t.Errorf("unexpected %s = %v", key, values[key])
+		}
+	}
+}
+
+// scrapCollector exposes the samples of a collector to a registry, leaving it
+// unchecked like the exporter does.
+type scrapCollector struct {
+	t         *testing.T
+	clickConn clickhouse.ClickhouseConn
+	collector Collector
+}
+
+func (c scrapCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c scrapCollector) Collect(ch chan<- prometheus.Metric) {
+	if err := c.collector.Scrap(context.Background(), c.clickConn, ch); err != nil {
+		c.t.Error(err)
+	}
+}
+
+func TestKeeperMetricsWithSystemMetrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metric := "KeeperOutstandingRequests"
+		if strings.Contains(r.URL.Query().Get("query"), "system.asynchronous_metrics") {
+			metric = "KeeperZnodeCount"
+		}
+		w.Write([]byte("metric\tvalue\nString\tFloat64\n" + metric + "\t5\n"))
+	}))
+	defer server.Close()
+	uri, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clickConn, err := clickhouse.NewClickhouseConn(*uri, "", "", nil, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	address := listenKeeper(t, map[string]string{
+		"ruok": "imok",
+		"mntr": "zk_outstanding_requests\t5\nzk_znode_count\t5\nzk_server_state\tstandalone\n",
+		"srvr": "ClickHouse Keeper version: v24.8.1.1-stable-abc\nZxid: 26\nMode: standalone\n",
+	})
+	config := readYamlString(t, "keeper_exporter:\n  targets:\n    - "+address+"\n")
+
+	basic := NewBasicMetricsExporter(*uri, "clickhouse", yaml.YamlConfig{}, "")
+	async := NewAsyncMetricsExporter(*uri, "clickhouse", yaml.YamlConfig{}, "")
+	keeper := NewKeeperMetricsExporter("clickhouse", config.GetMapObject("keeper_exporter"))
+
+	registry := prometheus.NewRegistry()
+	for _, collector := range []Collector{&basic, &async, &keeper} {
+		registry.MustRegister(scrapCollector{t: t, clickConn: clickConn, collector: collector})
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("gathering the keeper and the system metrics together failed: %v", err)
+	}
+
+	names := make(map[string]bool)
+	for _, family := range families {
+		names[family.GetName()] = true
+	}
+	for _, name := range []string{
+		"clickhouse_keeper_outstanding_requests",
+		"clickhouse_keeper_znode_count",
+		"clickhouse_keeper_mntr_outstanding_requests",
+		"clickhouse_keeper_mntr_znode_count",
+	} {
+		if !names[name] {
+			t.Errorf("missing %s", name)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package exporters
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/ClickHouse/clickhouse_exporter/internals/util"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/clickhouse"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/queryparser"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/yaml"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// Both queries share the filters of the exporter, they can use the database and
+// table columns.
+const (
+	MERGES_METRIC_EXPORTER_QUERY = `
+	select
+		database, table,
+		count() as merges,
+		min(progress) as min_progress,
+		max(elapsed) as max_elapsed,
+		sum(memory_usage) as memory_usage,
+		sum(total_size_bytes_compressed) as total_size_bytes_compressed {CLUSTER_COLUMNS}
+	from system.merges
+	{FILTER_CLAUSE}
+	group by database, table {CLUSTER_GROUP_BY}`
+
+	MUTATIONS_METRIC_EXPORTER_QUERY = `
+	select
+		database, table,
+		countIf(is_done) as done,
+		countIf(not is_done) as unfinished,
+		sumIf(parts_to_do, not is_done) as parts_to_do,
+		if(unfinished = 0, 0, dateDiff('second', minIf(create_time, not is_done), now())) as oldest_unfinished_age,
+		countIf(not is_done and latest_fail_reason != '') as failing {CLUSTER_COLUMNS}
+	from system.mutations
+	{FILTER_CLAUSE}
+	group by database, table {CLUSTER_GROUP_BY}`
+)
+
+func init() {
+	RegisterCollector("merges_exporter", func(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) Collector {
+		exporter := NewMergesMetricsExporter(uri, namespace, yamlconfig, cluster)
+		return &exporter
+	})
+}
+
+type MergesMetricsExporter struct {
+	Namespace    string
+	QueryURI     string
+	MutationsURI string
+
+	ClusterLabels util.ClusterLabels
+}
+
+func NewMergesMetricsExporter(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) MergesMetricsExporter {
+
+	query := queryparser.BuildQuery(MERGES_METRIC_EXPORTER_QUERY, yamlconfig, cluster)
+	log.Printf("merges exporter query: %v", query)
+	mutationsQuery := queryparser.BuildQuery(MUTATIONS_METRIC_EXPORTER_QUERY, yamlconfig, cluster)
+	log.Printf("merges exporter mutations query: %v", mutationsQuery)
+
+	url_values := uri.Query()
+	metricsURI := uri
+	url_values.Set("query", query)
+	metricsURI.RawQuery = url_values.Encode()
+
+	mutationsURI := uri
+	url_values.Set("query", mutationsQuery)
+	mutationsURI.RawQuery = url_values.Encode()
+
+	return MergesMetricsExporter{
+		QueryURI:      metricsURI.String(),
+		MutationsURI:  mutationsURI.String(),
+		Namespace:     namespace,
+		ClusterLabels: util.NewClusterLabels(cluster),
+	}
+}
+
+func (e *MergesMetricsExporter) Scrap(ctx context.Context, clickConn clickhouse.ClickhouseConn, ch chan<- prometheus.Metric) error {
+	merges, err := e.parseMerges(ctx, clickConn)
+	if err != nil {
+		return fmt.Errorf("error scraping clickhouse url %v: %v", e.QueryURI, err)
+	}
+	mutations, err := e.parseMutations(ctx, clickConn)
+	if err != nil {
+		return fmt.Errorf("error scraping clickhouse url %v: %v", e.MutationsURI, err)
+	}
+	e.collectMerges(merges, ch)
+	e.collectMutations(mutations, ch)
+	return nil
+}
+
+type MergesResult struct {
+	Database                 string  `ch:"database"`
+	Table                    string  `ch:"table"`
+	Merges                   int     `ch:"merges"`
+	MinProgress              float64 `ch:"min_progress"`
+	MaxElapsed               float64 `ch:"max_elapsed"`
+	MemoryUsage              int     `ch:"memory_usage"`
+	TotalSizeBytesCompressed int     `ch:"total_size_bytes_compressed"`
+	util.NodeLabels
+}
+
+type MutationsResult struct {
+	Database            string `ch:"database"`
+	Table               string `ch:"table"`
+	Done                int    `ch:"done"`
+	Unfinished          int    `ch:"unfinished"`
+	PartsToDo           int    `ch:"parts_to_do"`
+	OldestUnfinishedAge int    `ch:"oldest_unfinished_age"`
+	Failing             int    `ch:"failing"`
+	util.NodeLabels
+}
+
+func (e *MergesMetricsExporter) parseMerges(ctx context.Context, clickConn clickhouse.ClickhouseConn) ([]MergesResult, error) {
+	result, err := clickConn.Query(ctx, e.QueryURI)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []MergesResult
+	if err := result.Decode(&results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (e *MergesMetricsExporter) parseMutations(ctx context.Context, clickConn clickhouse.ClickhouseConn) ([]MutationsResult, error) {
+	result, err := clickConn.Query(ctx, e.MutationsURI)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []MutationsResult
+	if err := result.Decode(&results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (e *MergesMetricsExporter) collectMerges(resultLines []MergesResult, ch chan<- prometheus.Metric) {
+	metric_label := e.ClusterLabels.Names("database", "table")
+
+	for _, merge := range resultLines {
+		label_values := e.ClusterLabels.Values(merge.NodeLabels, merge.Database, merge.Table)
+
+		newMergesMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "table_merges_running",
+			Help:      "Number of merges running on the table",
+		}, metric_label).WithLabelValues(label_values...)
+		newMergesMetric.Set(float64(merge.Merges))
+		newMergesMetric.Collect(ch)
+
+		newProgressMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "table_merges_min_progress",
+			Help:      "Progress between 0 and 1 of the least advanced running merge",
+		}, metric_label).WithLabelValues(label_values...)
+		newProgressMetric.Set(merge.MinProgress)
+		newProgressMetric.Collect(ch)
+
+		newElapsedMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "table_merges_max_elapsed_seconds",
+			Help:      "Seconds elapsed since the oldest running merge started",
+		}, metric_label).WithLabelValues(label_values...)
+		newElapsedMetric.Set(merge.MaxElapsed)
+		newElapsedMetric.Collect(ch)
+
+		newMemoryUsageMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "table_merges_memory_usage_bytes",
+			Help:      "Memory used by the running merges in bytes",
+		}, metric_label).WithLabelValues(label_values...)
+		newMemoryUsageMetric.Set(float64(merge.MemoryUsage))
+		newMemoryUsageMetric.Collect(ch)
+
+		newSizeMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "table_merges_size_bytes_compressed",
+			Help:      "Compressed size of the parts being merged in bytes",
+		}, metric_label).WithLabelValues(label_values...)
+		newSizeMetric.Set(float64(merge.TotalSizeBytesCompressed))
+		newSizeMetric.Collect(ch)
+	}
+
+}
+
+func (e *MergesMetricsExporter) collectMutations(resultLines []MutationsResult, ch chan<- prometheus.Metric) {
+	metric_label := e.ClusterLabels.Names("database", "table")
+
+	for _, mutation := range resultLines {
+		label_values := e.ClusterLabels.Values(mutation.NodeLabels, mutation.Database, mutation.Table)
+
+		newDoneMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "table_mutations_done",
+			Help:      "Number of finished mutations still listed in system.mutations",
+		}, metric_label).WithLabelValues(label_values...)
+		newDoneMetric.Set(float64(mutation.Done))
+		newDoneMetric.Collect(ch)
+
+		newUnfinishedMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "table_mutations_unfinished",
+			Help:      "Number of mutations not done yet",
+		}, metric_label).WithLabelValues(label_values...)
+		newUnfinishedMetric.Set(float64(mutation.Unfinished))
+		newUnfinishedMetric.Collect(ch)
+
+		newPartsToDoMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "table_mutations_parts_to_do",
+			Help:      "Number of parts the unfinished mutations still have to mutate",
+		}, metric_label).WithLabelValues(label_values...)
+		newPartsToDoMetric.Set(float64(mutation.PartsToDo))
+		newPartsToDoMetric.Collect(ch)
+
+		newOldestAgeMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "table_mutations_oldest_unfinished_age_seconds",
+			Help:      "Seconds since the oldest unfinished mutation was created, 0 when none is left",
+		}, metric_label).WithLabelValues(label_values...)
+		newOldestAgeMetric.Set(float64(mutation.OldestUnfinishedAge))
+		newOldestAgeMetric.Collect(ch)
+
+		newFailingMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "table_mutations_failing",
+			Help:      "Number of unfinished mutations with a latest_fail_reason",
+		}, metric_label).WithLabelValues(label_values...)
+		newFailingMetric.Set(float64(mutation.Failing))
+		newFailingMetric.Collect(ch)
+	}
+
+}
@@ -1,11 +1,11 @@
 package exporters
 
 import (
+	"context"
 	"fmt"
 	"net/url"
-	"strconv"
-	"strings"
 
+	"github.com/ClickHouse/clickhouse_exporter/internals/util"
 	"github.com/ClickHouse/clickhouse_exporter/pkg/clickhouse"
 	"github.com/ClickHouse/clickhouse_exporter/pkg/queryparser"
 	"github.com/ClickHouse/clickhouse_exporter/pkg/yaml"
@@ -15,18 +15,26 @@ import (
 )
 
 const (
-	PARTS_METRIC_EXPORTER_QUERY = `select database, table, sum(bytes) as bytes, count() as parts, sum(rows) as rows from system.parts {FILTER_CLAUSE} group by database, table`
+	PARTS_METRIC_EXPORTER_QUERY = `select database, table, sum(bytes) as bytes, count() as parts, sum(rows) as rows {CLUSTER_COLUMNS} from system.parts {FILTER_CLAUSE} group by database, table {CLUSTER_GROUP_BY}`
 )
 
+func init() {
+	RegisterCollector("parts_exporter", func(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) Collector {
+		exporter := NewPartsMetricsExporter(uri, namespace, yamlconfig, cluster)
+		return &exporter
+	})
+}
+
 type PartsMetricsExporter struct {
 	Namespace string
 	QueryURI  string
+
+	ClusterLabels util.ClusterLabels
 }
 
-func NewPartsMetricsExporter(uri url.URL, namespace string, yamlconfig yaml.YamlConfig) PartsMetricsExporter {
+func NewPartsMetricsExporter(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) PartsMetricsExporter {
 
-	filter_calause := queryparser.ParseYamlConfigToQueryFilter(yamlconfig)
-	query := strings.Replace(PARTS_METRIC_EXPORTER_QUERY, "{FILTER_CLAUSE}", filter_calause, 1)
+	query := queryparser.BuildQuery(PARTS_METRIC_EXPORTER_QUERY, yamlconfig, cluster)
 	log.Printf("parts exporter query: %v", query)
 
 	url_values := uri.Query()
@@ -35,13 +43,14 @@ func NewPartsMetricsExporter(uri url.URL, namespace string, yamlconfig yaml.Yaml
 	metricsURI.RawQuery = url_values.Encode()
 
 	return PartsMetricsExporter{
-		QueryURI:  metricsURI.String(),
-		Namespace: namespace,
+		QueryURI:      metricsURI.String(),
+		Namespace:     namespace,
+		ClusterLabels: util.NewClusterLabels(cluster),
 	}
 }
 
-func (e *PartsMetricsExporter) Scrap(clickConn clickhouse.ClickhouseConn, ch chan<- prometheus.Metric) error {
-	parts, err := e.parseResponse(clickConn)
+func (e *PartsMetricsExporter) Scrap(ctx context.Context, clickConn clickhouse.ClickhouseConn, ch chan<- prometheus.Metric) error {
+	parts, err := e.parseResponse(ctx, clickConn)
 	if err != nil {
 		return fmt.Errorf("error scraping clickhouse url %v: %v", e.QueryURI, err)
 	}
@@ -50,52 +59,24 @@ func (e *PartsMetricsExporter) Scrap(clickConn clickhouse.ClickhouseConn, ch cha
 }
 
 type PartsResult struct {
-	database string
-	table    string
-	bytes    int
-	parts    int
-	rows     int
+	Database string `ch:"database"`
+	Table    string `ch:"table"`
+	Bytes    int    `ch:"bytes"`
+	Parts    int    `ch:"parts"`
+	Rows     int    `ch:"rows"`
+	util.NodeLabels
 }
 
-func (e *PartsMetricsExporter) parseResponse(clickConn clickhouse.ClickhouseConn) ([]PartsResult, error) {
-	data, err := clickConn.ExcecuteQuery(e.QueryURI)
+func (e *PartsMetricsExporter) parseResponse(ctx context.Context, clickConn clickhouse.ClickhouseConn) ([]PartsResult, error) {
+	result, err := clickConn.Query(ctx, e.QueryURI)
 	if err != nil {
 		return nil, err
 	}
 
-	// Parsing results
-	lines := strings.Split(string(data), "\n")
-	var results = make([]PartsResult, 0)
-
-	for i, line := range lines {
-		parts := strings.Fields(line)
-		if len(parts) == 0 {
-			continue
-		}
-		if len(parts) != 5 {
-			return nil, fmt.Errorf("parsePartsResponse: unexpected %d line: %s", i, line)
-		}
-		database := strings.TrimSpace(parts[0])
-		table := strings.TrimSpace(parts[1])
-
-		bytes, err := strconv.Atoi(strings.TrimSpace(parts[2]))
-		if err != nil {
-			return nil, err
-		}
-
-		count, err := strconv.Atoi(strings.TrimSpace(parts[3]))
-		if err != nil {
-			return nil, err
-		}
-
-		rows, err := strconv.Atoi(strings.TrimSpace(parts[4]))
-		if err != nil {
-			return nil, err
-		}
-
-		results = append(results, PartsResult{database, table, bytes, count, rows})
+	var results []PartsResult
+	if err := result.Decode(&results); err != nil {
+		return nil, err
 	}
-
 	return results, nil
 }
 
@@ -105,24 +86,24 @@ func (e *PartsMetricsExporter) collect(resultLines []PartsResult, ch chan<- prom
 			Namespace: e.Namespace,
 			Name:      "table_parts_bytes",
 			Help:      "Table size in bytes",
-		}, []string{"database", "table"}).WithLabelValues(part.database, part.table)
-		newBytesMetric.Set(float64(part.bytes))
+		}, e.ClusterLabels.Names("database", "table")).WithLabelValues(e.ClusterLabels.Values(part.NodeLabels, part.Database, part.Table)...)
+		newBytesMetric.Set(float64(part.Bytes))
 		newBytesMetric.Collect(ch)
 
 		newCountMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: e.Namespace,
 			Name:      "table_parts_count",
 			Help:      "Number of parts of the table",
-		}, []string{"database", "table"}).WithLabelValues(part.database, part.table)
-		newCountMetric.Set(float64(part.parts))
+		}, e.ClusterLabels.Names("database", "table")).WithLabelValues(e.ClusterLabels.Values(part.NodeLabels, part.Database, part.Table)...)
+		newCountMetric.Set(float64(part.Parts))
 		newCountMetric.Collect(ch)
 
 		newRowsMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: e.Namespace,
 			Name:      "table_parts_rows",
 			Help:      "Number of rows in the table",
-		}, []string{"database", "table"}).WithLabelValues(part.database, part.table)
-		newRowsMetric.Set(float64(part.rows))
+		}, e.ClusterLabels.Names("database", "table")).WithLabelValues(e.ClusterLabels.Values(part.NodeLabels, part.Database, part.Table)...)
+		newRowsMetric.Set(float64(part.Rows))
 		newRowsMetric.Collect(ch)
 	}
 
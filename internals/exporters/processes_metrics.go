@@ -0,0 +1,150 @@
+package exporters
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ClickHouse/clickhouse_exporter/internals/util"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/clickhouse"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/queryparser"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/yaml"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	PROCESSES_METRIC_EXPORTER_QUERY = `
+	select
+		user, query_kind,
+		count() as queries,
+		max(elapsed) as max_elapsed,
+		sum(memory_usage) as memory_usage,
+		sum(read_rows) as read_rows,
+		countIf(elapsed > {SLOW_QUERY_THRESHOLD}) as slow_queries {CLUSTER_COLUMNS}
+	from system.processes
+	{FILTER_CLAUSE}
+	group by user, query_kind {CLUSTER_GROUP_BY}`
+
+	DEFAULT_SLOW_QUERY_THRESHOLD = time.Minute
+)
+
+func init() {
+	RegisterCollector("processes_exporter", func(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) Collector {
+		exporter := NewProcessesMetricsExporter(uri, namespace, yamlconfig, cluster)
+		return &exporter
+	})
+}
+
+type ProcessesMetricsExporter struct {
+	Namespace string
+	QueryURI  string
+
+	ClusterLabels util.ClusterLabels
+}
+
+func NewProcessesMetricsExporter(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) ProcessesMetricsExporter {
+
+	threshold := yamlconfig.GetDuration("slow_query_threshold", DEFAULT_SLOW_QUERY_THRESHOLD)
+	template := strings.Replace(PROCESSES_METRIC_EXPORTER_QUERY, "{SLOW_QUERY_THRESHOLD}",
+		strconv.FormatFloat(threshold.Seconds(), 'f', -1, 64), 1)
+	query := queryparser.BuildQuery(template, yamlconfig, cluster)
+	log.Printf("processes exporter query: %v", query)
+
+	url_values := uri.Query()
+	metricsURI := uri
+	url_values.Set("query", query)
+	metricsURI.RawQuery = url_values.Encode()
+
+	return ProcessesMetricsExporter{
+		QueryURI:      metricsURI.String(),
+		Namespace:     namespace,
+		ClusterLabels: util.NewClusterLabels(cluster),
+	}
+}
+
+func (e *ProcessesMetricsExporter) Scrap(ctx context.Context, clickConn clickhouse.ClickhouseConn, ch chan<- prometheus.Metric) error {
+	processes, err := e.parseResponse(ctx, clickConn)
+	if err != nil {
+		return fmt.Errorf("error scraping clickhouse url %v: %v", e.QueryURI, err)
+	}
+	e.collect(processes, ch)
+	return nil
+}
+
+type ProcessesResult struct {
+	User        string  `ch:"user"`
+	QueryKind   string  `ch:"query_kind"`
+	Queries     int     `ch:"queries"`
+	MaxElapsed  float64 `ch:"max_elapsed"`
+	MemoryUsage int     `ch:"memory_usage"`
+	ReadRows    int     `ch:"read_rows"`
+	SlowQueries int     `ch:"slow_queries"`
+	util.NodeLabels
+}
+
+func (e *ProcessesMetricsExporter) parseResponse(ctx context.Context, clickConn clickhouse.ClickhouseConn) ([]ProcessesResult, error) {
+	result, err := clickConn.Query(ctx, e.QueryURI)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []ProcessesResult
+	if err := result.Decode(&results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (e *ProcessesMetricsExporter) collect(resultLines []ProcessesResult, ch chan<- prometheus.Metric) {
+	metric_label := e.ClusterLabels.Names("user", "kind")
+
+	for _, process := range resultLines {
+		label_values := e.ClusterLabels.Values(process.NodeLabels, process.User, process.QueryKind)
+
+		newQueriesMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "running_queries",
+			Help:      "Number of queries running",
+		}, metric_label).WithLabelValues(label_values...)
+		newQueriesMetric.Set(float64(process.Queries))
+		newQueriesMetric.Collect(ch)
+
+		newMaxElapsedMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "running_queries_max_elapsed_seconds",
+			Help:      "Seconds since the oldest running query started",
+		}, metric_label).WithLabelValues(label_values...)
+		newMaxElapsedMetric.Set(process.MaxElapsed)
+		newMaxElapsedMetric.Collect(ch)
+
+		newMemoryUsageMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "running_queries_memory_usage_bytes",
+			Help:      "Memory used by the running queries in bytes",
+		}, metric_label).WithLabelValues(label_values...)
+		newMemoryUsageMetric.Set(float64(process.MemoryUsage))
+		newMemoryUsageMetric.Collect(ch)
+
+		newReadRowsMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "running_queries_read_rows",
+			Help:      "Number of rows read so far by the running queries",
+		}, metric_label).WithLabelValues(label_values...)
+		newReadRowsMetric.Set(float64(process.ReadRows))
+		newReadRowsMetric.Collect(ch)
+
+		newSlowQueriesMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "running_queries_slow",
+			Help:      "Number of running queries older than slow_query_threshold",
+		}, metric_label).WithLabelValues(label_values...)
+		newSlowQueriesMetric.Set(float64(process.SlowQueries))
+		newSlowQueriesMetric.Collect(ch)
+	}
+
+}
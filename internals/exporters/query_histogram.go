@@ -0,0 +1,122 @@
+package exporters
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ClickHouse/clickhouse_exporter/internals/util"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/clickhouse"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// {BUCKET_COLUMNS} holds one countIf(query_duration_ms <= bound) column per
+	// bucket, the counts are cumulative like the buckets of a histogram
+	QUERY_DURATION_HISTOGRAM_QUERY = `
+	SELECT 
+		user, query_kind, arrayJoin(tables) AS table,
+		count(*) AS query_num,
+		sum(query_duration_ms) as query_duration_ms,
+		{BUCKET_COLUMNS} {CLUSTER_COLUMNS}
+	FROM system.query_log 
+	{FILTER_CLAUSE}
+	GROUP BY user, table, query_kind {CLUSTER_GROUP_BY}`
+
+	// QueryStart rows have no duration yet
+	QUERY_DURATION_FILTER = "type != 'QueryStart'"
+)
+
+type QueryDurationResult struct {
+	User            string `ch:"user"`
+	QueryKind       string `ch:"query_kind"`
+	Table           string `ch:"table"`
+	QueryNum        uint64 `ch:"query_num"`
+	QueryDurationMs uint64 `ch:"query_duration_ms"`
+	util.NodeLabels
+}
+
+// queryDurationSeries is one histogram of query durations, bucketCounts follows
+// the order of the buckets of the exporter.
+type queryDurationSeries struct {
+	labelValues  []string
+	count        uint64
+	sumMs        uint64
+	bucketCounts []uint64
+}
+
+// durationBucketColumns renders the {BUCKET_COLUMNS} of the histogram query for
+// buckets given in seconds.
+func durationBucketColumns(buckets []float64) string {
+	columns := make([]string, len(buckets))
+	for i, bucket := range buckets {
+		columns[i] = fmt.Sprintf("countIf(query_duration_ms <= %s) AS bucket_%d",
+			strconv.FormatFloat(bucket*1000, 'f', -1, 64), i)
+	}
+	return strings.Join(columns, ",\n\t\t")
+}
+
+// sortedDurationBuckets checks the buckets configured in the yaml, in seconds.
+func sortedDurationBuckets(buckets []float64) []float64 {
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	for i, bucket := range sorted {
+		if bucket <= 0 || (i > 0 && bucket == sorted[i-1]) {
+			panic("error: duration_buckets must be distinct positive numbers of seconds")
+		}
+	}
+	return sorted
+}
+
+func (e *QueryMetricsExporter) parseDurations(ctx context.Context, clickConn clickhouse.ClickhouseConn, uri string) ([]queryDurationSeries, error) {
+	result, err := clickConn.Query(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []QueryDurationResult
+	if err := result.Decode(&results); err != nil {
+		return nil, err
+	}
+
+	bucketColumns := make([]int, len(e.DurationBuckets))
+	for i := range bucketColumns {
+		if bucketColumns[i] = result.ColumnIndex(fmt.Sprintf("bucket_%d", i)); bucketColumns[i] < 0 {
+			return nil, fmt.Errorf("result has no column bucket_%d", i)
+		}
+	}
+
+	series := make([]queryDurationSeries, len(results))
+	for i, row := range results {
+		series[i] = queryDurationSeries{
+			labelValues:  e.ClusterLabels.Values(row.NodeLabels, row.User, row.Table, row.QueryKind),
+			count:        row.QueryNum,
+			sumMs:        row.QueryDurationMs,
+			bucketCounts: make([]uint64, len(bucketColumns)),
+		}
+		for j, column := range bucketColumns {
+			if series[i].bucketCounts[j], err = strconv.ParseUint(result.Rows[i][column], 10, 64); err != nil {
+				return nil, fmt.Errorf("column bucket_%d: %v", j, err)
+			}
+		}
+	}
+	return series, nil
+}
+
+func (e *QueryMetricsExporter) collectDurations(series []queryDurationSeries, ch chan<- prometheus.Metric) {
+	desc := prometheus.NewDesc(
+		prometheus.BuildFQName(e.Namespace, "", "query_duration_seconds"),
+		"Duration of finished queries in seconds",
+		e.ClusterLabels.Names("user", "table", "kind"), nil,
+	)
+	for _, histogram := range series {
+		buckets := make(map[float64]uint64, len(e.DurationBuckets))
+		for i, bound := range e.DurationBuckets {
+			buckets[bound] = histogram.bucketCounts[i]
+		}
+		ch <- prometheus.MustNewConstHistogram(desc, histogram.count, float64(histogram.sumMs)/1000, buckets, histogram.labelValues...)
+	}
+}
@@ -1,11 +1,15 @@
 package exporters
 
 import (
+	"context"
 	"fmt"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/ClickHouse/clickhouse_exporter/internals/util"
 	"github.com/ClickHouse/clickhouse_exporter/pkg/clickhouse"
 	"github.com/ClickHouse/clickhouse_exporter/pkg/queryparser"
 	"github.com/ClickHouse/clickhouse_exporter/pkg/yaml"
@@ -27,233 +31,369 @@ const (
 		sum(written_rows) as written_rows,
 		sum(result_bytes) as result_bytes,
 		sum(result_rows) as result_rows,
-		sum(peak_threads_usage) as peak_threads_usage
+		sum(peak_threads_usage) as peak_threads_usage {CLUSTER_COLUMNS}
 	FROM system.query_log 
 	{FILTER_CLAUSE}
-	GROUP BY user, table, type,query_kind`
+	GROUP BY user, table, type,query_kind {CLUSTER_GROUP_BY}`
+
+	// window of query_log read by an incremental scrape, event_date prunes the
+	// partitions outside of it
+	QUERY_LOG_WINDOW_FILTER = `event_date >= toDate(toDateTime({SINCE})) AND
+event_time > toDateTime({SINCE}) AND
+event_time <= toDateTime({UNTIL})`
+
+	// query_log is flushed every 7.5 seconds by default, rows younger than the lag
+	// may still be on their way
+	DEFAULT_QUERY_LOG_LAG = 15 * time.Second
 )
 
+func init() {
+	RegisterCollector("query_exporter", func(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) Collector {
+		exporter := NewQueryMetricsExporter(uri, namespace, yamlconfig, cluster)
+		return &exporter
+	})
+}
+
 type QueryMetricsExporter struct {
 	Namespace string
 	QueryURI  string
-}
 
-func NewQueryMetricsExporter(uri url.URL, namespace string, yamlconfig yaml.YamlConfig) QueryMetricsExporter {
+	ClusterLabels util.ClusterLabels
 
-	filter_calause := queryparser.ParseYamlConfigToQueryFilter(yamlconfig)
-	query := strings.Replace(QUERY_METRIC_EXPORTER_QUERY, "{FILTER_CLAUSE}", filter_calause, 1)
-	log.Printf("query exporter query: %v", query)
-
-	url_values := uri.Query()
-	metricsURI := uri
-	url_values.Set("query", query)
-	metricsURI.RawQuery = url_values.Encode()
+	// DurationBuckets are the upper bounds in seconds of the query duration
+	// histograms, none are exported when empty.
+	DurationBuckets []float64
+	DurationURI     string
 
-	return QueryMetricsExporter{
-		QueryURI:  metricsURI.String(),
-		Namespace: namespace,
-	}
+	// Incremental reads only the rows logged since the previous scrape and
+	// exports their running totals as counters.
+	Incremental bool
+	Lag         time.Duration
+	incremental *queryLogTotals
 }
 
-func (e *QueryMetricsExporter) Scrap(clickConn clickhouse.ClickhouseConn, ch chan<- prometheus.Metric) error {
-	query_metrics, err := e.parseResponse(clickConn)
-	if err != nil {
-		return fmt.Errorf("error scraping clickhouse url %v: %v", e.QueryURI, err)
-	}
-	e.collect(query_metrics, ch)
+// queryLogTotals holds what the incremental scrapes have read so far. Rows are
+// read up to Lag ago, the watermark is where the next scrape resumes.
+type queryLogTotals struct {
+	mu            sync.Mutex
+	uri           url.URL
+	query         string
+	durationQuery string
+	watermark     time.Time
+	series        map[string]*queryLogSeries
+	durations     map[string]*queryDurationSeries
+}
 
-	return nil
+type queryLogSeries struct {
+	labelValues []string
+	values      [len(queryLogCounters)]float64
 }
 
-type QueryMetricsResult struct {
-	user               string
-	query_type         string
-	query_kind         string
-	table              string
-	memory_usage       int
-	query_num          int
-	query_duration_ms  int
-	read_bytes         int
-	read_rows          int
-	written_bytes      int
-	written_rows       int
-	result_bytes       int
-	result_rows        int
-	peak_threads_usage int
+// counters exported by the incremental mode, in the order of their values in
+// queryLogSeries
+var queryLogCounters = [...]struct {
+	name  string
+	help  string
+	value func(row QueryMetricsResult) int
+}{
+	{"user_query_num_total", "Number of queries that user ran", func(row QueryMetricsResult) int { return row.QueryNum }},
+	{"user_query_duration_ms_total", "Duration of queries in milliseconds", func(row QueryMetricsResult) int { return row.QueryDurationMs }},
+	{"user_memory_usage_total", "Memory used by queries in bytes", func(row QueryMetricsResult) int { return row.MemoryUsage }},
+	{"user_read_bytes_total", "Volume of read rows in bytes", func(row QueryMetricsResult) int { return row.ReadBytes }},
+	{"user_read_rows_total", "Number of rows that user read", func(row QueryMetricsResult) int { return row.ReadRows }},
+	{"user_written_bytes_total", "Number of bytes that user wrote", func(row QueryMetricsResult) int { return row.WrittenBytes }},
+	{"user_written_rows_total", "Number of rows that user wrote", func(row QueryMetricsResult) int { return row.WrittenRows }},
+	{"user_result_bytes_total", "Number of result bytes", func(row QueryMetricsResult) int { return row.ResultBytes }},
+	{"user_result_rows_total", "Number of result rows", func(row QueryMetricsResult) int { return row.ResultRows }},
 }
 
-func (e *QueryMetricsExporter) parseResponse(clickConn clickhouse.ClickhouseConn) ([]QueryMetricsResult, error) {
-	data, err := clickConn.ExcecuteQuery(e.QueryURI)
-	if err != nil {
-		return nil, err
+func NewQueryMetricsExporter(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) QueryMetricsExporter {
+
+	exporter := QueryMetricsExporter{
+		Namespace:       namespace,
+		ClusterLabels:   util.NewClusterLabels(cluster),
+		DurationBuckets: sortedDurationBuckets(yamlconfig.GetFloatList("duration_buckets")),
+		Incremental:     yamlconfig.GetBool("incremental", false),
 	}
 
-	// Parsing results
-	lines := strings.Split(string(data), "\n")
-	var results = make([]QueryMetricsResult, 0)
+	// the incremental queries keep {SINCE} and {UNTIL} to be filled by each scrape
+	var conditions []string
+	if exporter.Incremental {
+		conditions = append(conditions, QUERY_LOG_WINDOW_FILTER)
+	}
 
-	for i, line := range lines {
-		parts := strings.Fields(line)
-		if len(parts) == 0 {
-			continue
-		}
-		if len(parts) != 14 {
-			return nil, fmt.Errorf("parseQueryResponse: unexpected %d line: %s", i, line)
-		}
-		user := strings.TrimSpace(parts[0])
-		query_type := strings.TrimSpace(parts[1])
-		query_kind := strings.TrimSpace(parts[2])
-		table := strings.TrimSpace(parts[3])
+	query := queryparser.BuildQuery(
+		strings.Replace(QUERY_METRIC_EXPORTER_QUERY, "{FILTER_CLAUSE}", queryLogFilterClause(yamlconfig, conditions...), 1),
+		yamlconfig, cluster)
+	log.Printf("query exporter query: %v", query)
 
-		memory_usage, err := strconv.Atoi(strings.TrimSpace(parts[4]))
-		if err != nil {
-			return nil, err
-		}
+	var durationQuery string
+	if len(exporter.DurationBuckets) > 0 {
+		template := strings.NewReplacer(
+			"{BUCKET_COLUMNS}", durationBucketColumns(exporter.DurationBuckets),
+			"{FILTER_CLAUSE}", queryLogFilterClause(yamlconfig, append(conditions, QUERY_DURATION_FILTER)...),
+		).Replace(QUERY_DURATION_HISTOGRAM_QUERY)
+		durationQuery = queryparser.BuildQuery(template, yamlconfig, cluster)
+		log.Printf("query exporter duration query: %v", durationQuery)
+	}
 
-		query_num, err := strconv.Atoi(strings.TrimSpace(parts[5]))
-		if err != nil {
-			return nil, err
+	if exporter.Incremental {
+		exporter.Lag = yamlconfig.GetDuration("lag", DEFAULT_QUERY_LOG_LAG)
+		exporter.incremental = &queryLogTotals{
+			uri:           uri,
+			query:         query,
+			durationQuery: durationQuery,
+			watermark:     time.Now().Add(-exporter.Lag).Truncate(time.Second),
+			series:        make(map[string]*queryLogSeries),
+			durations:     make(map[string]*queryDurationSeries),
 		}
+		return exporter
+	}
 
-		query_duration_ms, err := strconv.Atoi(strings.TrimSpace(parts[6]))
-		if err != nil {
-			return nil, err
-		}
+	exporter.QueryURI = queryLogURI(uri, query)
+	if durationQuery != "" {
+		exporter.DurationURI = queryLogURI(uri, durationQuery)
+	}
+	return exporter
+}
 
-		read_bytes, err := strconv.Atoi(strings.TrimSpace(parts[7]))
-		if err != nil {
-			return nil, err
+// queryLogFilterClause is the filter clause of the yaml object with conditions
+// of the collector itself added. The filters of the yaml object are put in
+// parentheses, an OR among them must not take the conditions in.
+func queryLogFilterClause(yamlconfig yaml.YamlConfig, conditions ...string) string {
+	filterClause := queryparser.ParseYamlConfigToQueryFilter(yamlconfig)
+	if filterClause != "" {
+		filterClause = "WHERE\n(" + strings.TrimPrefix(filterClause, "WHERE\n") + ")"
+	}
+	for _, condition := range conditions {
+		if filterClause == "" {
+			filterClause = "WHERE\n" + condition
+		} else {
+			filterClause += " AND\n" + condition
 		}
+	}
+	return filterClause
+}
 
-		read_rows, err := strconv.Atoi(strings.TrimSpace(parts[8]))
-		if err != nil {
-			return nil, err
-		}
+func queryLogURI(uri url.URL, query string) string {
+	url_values := uri.Query()
+	metricsURI := uri
+	url_values.Set("query", query)
+	metricsURI.RawQuery = url_values.Encode()
+	return metricsURI.String()
+}
 
-		written_bytes, err := strconv.Atoi(strings.TrimSpace(parts[9]))
-		if err != nil {
-			return nil, err
-		}
+func (e *QueryMetricsExporter) Scrap(ctx context.Context, clickConn clickhouse.ClickhouseConn, ch chan<- prometheus.Metric) error {
+	if e.Incremental {
+		return e.scrapIncremental(ctx, clickConn, ch)
+	}
 
-		written_rows, err := strconv.Atoi(strings.TrimSpace(parts[10]))
-		if err != nil {
-			return nil, err
-		}
+	query_metrics, err := e.parseResponse(ctx, clickConn)
+	if err != nil {
+		return fmt.Errorf("error scraping clickhouse url %v: %v", e.QueryURI, err)
+	}
+	e.collect(query_metrics, ch)
 
-		result_bytes, err := strconv.Atoi(strings.TrimSpace(parts[11]))
+	if e.DurationURI != "" {
+		durations, err := e.parseDurations(ctx, clickConn, e.DurationURI)
 		if err != nil {
-			return nil, err
+			return fmt.Errorf("error scraping clickhouse url %v: %v", e.DurationURI, err)
 		}
+		e.collectDurations(durations, ch)
+	}
 
-		result_rows, err := strconv.Atoi(strings.TrimSpace(parts[12]))
-		if err != nil {
-			return nil, err
-		}
+	return nil
+}
 
-		peak_threads_usage, err := strconv.Atoi(strings.TrimSpace(parts[13]))
-		if err != nil {
-			return nil, err
-		}
+type QueryMetricsResult struct {
+	User             string `ch:"user"`
+	QueryType        string `ch:"status"`
+	QueryKind        string `ch:"query_kind"`
+	Table            string `ch:"table"`
+	MemoryUsage      int    `ch:"memory_usage"`
+	QueryNum         int    `ch:"query_num"`
+	QueryDurationMs  int    `ch:"query_duration_ms"`
+	ReadBytes        int    `ch:"read_bytes"`
+	ReadRows         int    `ch:"read_rows"`
+	WrittenBytes     int    `ch:"written_bytes"`
+	WrittenRows      int    `ch:"written_rows"`
+	ResultBytes      int    `ch:"result_bytes"`
+	ResultRows       int    `ch:"result_rows"`
+	PeakThreadsUsage int    `ch:"peak_threads_usage"`
+	util.NodeLabels
+}
 
-		results = append(results, QueryMetricsResult{
-			user:               user,
-			query_type:         query_type,
-			query_kind:         query_kind,
-			table:              table,
-			memory_usage:       memory_usage,
-			query_num:          query_num,
-			query_duration_ms:  query_duration_ms,
-			read_bytes:         read_bytes,
-			read_rows:          read_rows,
-			written_bytes:      written_bytes,
-			written_rows:       written_rows,
-			result_bytes:       result_bytes,
-			result_rows:        result_rows,
-			peak_threads_usage: peak_threads_usage,
-		})
+func (e *QueryMetricsExporter) parseResponse(ctx context.Context, clickConn clickhouse.ClickhouseConn) ([]QueryMetricsResult, error) {
+	return parseQueryMetrics(ctx, clickConn, e.QueryURI)
+}
+
+func parseQueryMetrics(ctx context.Context, clickConn clickhouse.ClickhouseConn, uri string) ([]QueryMetricsResult, error) {
+	result, err := clickConn.Query(ctx, uri)
+	if err != nil {
+		return nil, err
 	}
 
+	var results []QueryMetricsResult
+	if err := result.Decode(&results); err != nil {
+		return nil, err
+	}
 	return results, nil
-
 }
 
 func (e *QueryMetricsExporter) collect(resultLines []QueryMetricsResult, ch chan<- prometheus.Metric) {
 
 	for _, query_metrics := range resultLines {
 
-		metric_label := []string{"user", "table", "type", "kind"}
+		metric_label := e.ClusterLabels.Names("user", "table", "type", "kind")
+		label_values := e.ClusterLabels.Values(query_metrics.NodeLabels, query_metrics.User, query_metrics.Table, query_metrics.QueryType, query_metrics.QueryKind)
 
 		newMemoryUsageMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: e.Namespace,
 			Name:      "user_memory_usage",
 			Help:      "user memory use in bytes",
-		}, metric_label).WithLabelValues(query_metrics.user, query_metrics.table, query_metrics.query_type, query_metrics.query_kind)
-		newMemoryUsageMetric.Set(float64(query_metrics.memory_usage))
+		}, metric_label).WithLabelValues(label_values...)
+		newMemoryUsageMetric.Set(float64(query_metrics.MemoryUsage))
 		newMemoryUsageMetric.Collect(ch)
 
 		newQueryNumMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: e.Namespace,
 			Name:      "user_query_num",
 			Help:      "Number of Queries that user run",
-		}, metric_label).WithLabelValues(query_metrics.user, query_metrics.table, query_metrics.query_type, query_metrics.query_kind)
-		newQueryNumMetric.Set(float64(query_metrics.query_num))
+		}, metric_label).WithLabelValues(label_values...)
+		newQueryNumMetric.Set(float64(query_metrics.QueryNum))
 		newQueryNumMetric.Collect(ch)
 
 		newQueryDurationMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: e.Namespace,
 			Name:      "user_query_duration_ms",
 			Help:      "Duration of Queries in mili seconds",
-		}, metric_label).WithLabelValues(query_metrics.user, query_metrics.table, query_metrics.query_type, query_metrics.query_kind)
-		newQueryDurationMetric.Set(float64(query_metrics.query_duration_ms))
+		}, metric_label).WithLabelValues(label_values...)
+		newQueryDurationMetric.Set(float64(query_metrics.QueryDurationMs))
 		newQueryDurationMetric.Collect(ch)
 
 		newReadBytesMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: e.Namespace,
 			Name:      "user_read_bytes",
 			Help:      "Volume of red rows in bytes",
-		}, metric_label).WithLabelValues(query_metrics.user, query_metrics.table, query_metrics.query_type, query_metrics.query_kind)
-		newReadBytesMetric.Set(float64(query_metrics.read_bytes))
+		}, metric_label).WithLabelValues(label_values...)
+		newReadBytesMetric.Set(float64(query_metrics.ReadBytes))
 		newReadBytesMetric.Collect(ch)
 
 		newWrittenBytes := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: e.Namespace,
 			Name:      "user_written_bytes",
 			Help:      "Number of bytes that user write",
-		}, metric_label).WithLabelValues(query_metrics.user, query_metrics.table, query_metrics.query_type, query_metrics.query_kind)
-		newWrittenBytes.Set(float64(query_metrics.written_bytes))
+		}, metric_label).WithLabelValues(label_values...)
+		newWrittenBytes.Set(float64(query_metrics.WrittenBytes))
 		newWrittenBytes.Collect(ch)
 
 		newWrittenRows := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: e.Namespace,
 			Name:      "user_written_rows",
 			Help:      "Number of rows that user write",
-		}, metric_label).WithLabelValues(query_metrics.user, query_metrics.table, query_metrics.query_type, query_metrics.query_kind)
-		newWrittenRows.Set(float64(query_metrics.written_rows))
+		}, metric_label).WithLabelValues(label_values...)
+		newWrittenRows.Set(float64(query_metrics.WrittenRows))
 		newWrittenRows.Collect(ch)
 
 		newResultBytes := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: e.Namespace,
 			Name:      "user_result_bytes",
 			Help:      "Number of result bytes",
-		}, metric_label).WithLabelValues(query_metrics.user, query_metrics.table, query_metrics.query_type, query_metrics.query_kind)
-		newResultBytes.Set(float64(query_metrics.result_bytes))
+		}, metric_label).WithLabelValues(label_values...)
+		newResultBytes.Set(float64(query_metrics.ResultBytes))
 		newResultBytes.Collect(ch)
 
 		newResultRows := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: e.Namespace,
 			Name:      "user_result_rows",
 			Help:      "Number of result rows",
-		}, metric_label).WithLabelValues(query_metrics.user, query_metrics.table, query_metrics.query_type, query_metrics.query_kind)
-		newResultRows.Set(float64(query_metrics.result_rows))
+		}, metric_label).WithLabelValues(label_values...)
+		newResultRows.Set(float64(query_metrics.ResultRows))
 		newResultRows.Collect(ch)
 
 		newPeakThreadUsage := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: e.Namespace,
 			Name:      "user_peak_thread_usage",
 			Help:      "number of threads in the peak",
-		}, metric_label).WithLabelValues(query_metrics.user, query_metrics.table, query_metrics.query_type, query_metrics.query_kind)
-		newPeakThreadUsage.Set(float64(query_metrics.peak_threads_usage))
+		}, metric_label).WithLabelValues(label_values...)
+		newPeakThreadUsage.Set(float64(query_metrics.PeakThreadsUsage))
 		newPeakThreadUsage.Collect(ch)
 	}
 
 }
+
+// scrapIncremental adds the rows logged since the watermark to the totals and
+// exports them. The watermark only moves forward once the rows are counted, a
+// failed scrape is read again by the next one. Scrapes are serialized so a row
+// is never counted twice.
+func (e *QueryMetricsExporter) scrapIncremental(ctx context.Context, clickConn clickhouse.ClickhouseConn, ch chan<- prometheus.Metric) error {
+	totals := e.incremental
+	totals.mu.Lock()
+	defer totals.mu.Unlock()
+
+	until := time.Now().Add(-e.Lag).Truncate(time.Second)
+	if until.After(totals.watermark) {
+		window := strings.NewReplacer(
+			"{SINCE}", strconv.FormatInt(totals.watermark.Unix(), 10),
+			"{UNTIL}", strconv.FormatInt(until.Unix(), 10),
+		)
+
+		queryURI := queryLogURI(totals.uri, window.Replace(totals.query))
+		query_metrics, err := parseQueryMetrics(ctx, clickConn, queryURI)
+		if err != nil {
+			return fmt.Errorf("error scraping clickhouse url %v: %v", queryURI, err)
+		}
+		var durations []queryDurationSeries
+		if totals.durationQuery != "" {
+			durationURI := queryLogURI(totals.uri, window.Replace(totals.durationQuery))
+			if durations, err = e.parseDurations(ctx, clickConn, durationURI); err != nil {
+				return fmt.Errorf("error scraping clickhouse url %v: %v", durationURI, err)
+			}
+		}
+
+		for _, row := range query_metrics {
+			labelValues := e.ClusterLabels.Values(row.NodeLabels, row.User, row.Table, row.QueryType, row.QueryKind)
+			key := strings.Join(labelValues, "\x00")
+			series, exists := totals.series[key]
+			if !exists {
+				series = &queryLogSeries{labelValues: labelValues}
+				totals.series[key] = series
+			}
+			for i, counter := range queryLogCounters {
+				series.values[i] += float64(counter.value(row))
+			}
+		}
+		for _, histogram := range durations {
+			key := strings.Join(histogram.labelValues, "\x00")
+			series, exists := totals.durations[key]
+			if !exists {
+				series = &queryDurationSeries{
+					labelValues:  histogram.labelValues,
+					bucketCounts: make([]uint64, len(histogram.bucketCounts)),
+				}
+				totals.durations[key] = series
+			}
+			series.count += histogram.count
+			series.sumMs += histogram.sumMs
+			for i, count := range histogram.bucketCounts {
+				series.bucketCounts[i] += count
+			}
+		}
+		totals.watermark = until
+	}
+
+	metric_label := e.ClusterLabels.Names("user", "table", "type", "kind")
+	for i, counter := range queryLogCounters {
+		desc := prometheus.NewDesc(prometheus.BuildFQName(e.Namespace, "", counter.name), counter.help, metric_label, nil)
+		for _, series := range totals.series {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, series.values[i], series.labelValues...)
+		}
+	}
+
+	durations := make([]queryDurationSeries, 0, len(totals.durations))
+	for _, series := range totals.durations {
+		durations = append(durations, *series)
+	}
+	e.collectDurations(durations, ch)
+	return nil
+}
@@ -0,0 +1,288 @@
+package exporters
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ClickHouse/clickhouse_exporter/pkg/clickhouse"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/yaml"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	queryLogResponse = "user\tstatus\tquery_kind\ttable\tmemory_usage\tquery_num\tquery_duration_ms\tread_bytes\tread_rows\twritten_bytes\twritten_rows\tresult_bytes\tresult_rows\tpeak_threads_usage\n" +
+		"String\tString\tString\tString\tUInt64\tUInt64\tUInt64\tUInt64\tUInt64\tUInt64\tUInt64\tUInt64\tUInt64\tUInt64\n" +
+		"alice\tQueryFinish\tSelect\tdb.events\t100\t2\t300\t10\t1\t0\t0\t5\t1\t4\n"
+	queryDurationResponse = "user\tquery_kind\ttable\tquery_num\tquery_duration_ms\tbucket_0\n" +
+		"String\tString\tString\tUInt64\tUInt64\tUInt64\n" +
+		"alice\tSelect\tdb.events\t2\t300\t1\n"
+)
+
+var (
+	queryLogWindow = regexp.MustCompile(`event_time > toDateTime\((\d+)\) AND\s+event_time <= toDateTime\((\d+)\)`)
+	descName       = regexp.MustCompile(`fqName: "([^"]+)"`)
+)
+
+type queryLogWindowRequest struct {
+	duration     bool
+	since, until int64
+}
+
+// fakeQueryLog answers the incremental query_log queries and records the window
+// each of them reads. Queries fail while failing holds for them.
+type fakeQueryLog struct {
+	mu       sync.Mutex
+	requests []queryLogWindowRequest
+	failing  func(duration bool) bool
+}
+
+func (f *fakeQueryLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("query")
+	match := queryLogWindow.FindStringSubmatch(query)
+	if match == nil {
+		http.Error(w, "query has no window: "+query, http.StatusBadRequest)
+		return
+	}
+	request := queryLogWindowRequest{duration: strings.Contains(query, "bucket_0")}
+	request.since, _ = strconv.ParseInt(match[1], 10, 64)
+	request.until, _ = strconv.ParseInt(match[2], 10, 64)
+
+	f.mu.Lock()
+	f.requests = append(f.requests, request)
+	failing := f.failing != nil && f.failing(request.duration)
+	f.mu.Unlock()
+
+	if failing {
+		http.Error(w, "Code: 241. DB::Exception: Memory limit exceeded", http.StatusInternalServerError)
+		return
+	}
+	if request.duration {
+		w.Write([]byte(queryDurationResponse))
+	} else {
+		w.Write([]byte(queryLogResponse))
+	}
+}
+
+// takeRequests returns the requests recorded since the previous call.
+func (f *fakeQueryLog) takeRequests() []queryLogWindowRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	requests := f.requests
+	f.requests = nil
+	return requests
+}
+
+func (f *fakeQueryLog) setFailing(failing func(duration bool) bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failing = failing
+}
+
+func readYamlString(t *testing.T, content string) yaml.YamlConfig {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "query-filters.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return yaml.ReadYaml(path)
+}
+
+// collectSamples runs scrap and returns the value of each sample it sends,
+// keyed like name{label="value"} with the labels sorted. Histograms give their
+// sample count.
+func collectSamples(t *testing.T, scrap func(ch chan<- prometheus.Metric) error) (map[string]float64, error) {
+	t.Helper()
+	ch := make(chan prometheus.Metric)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- scrap(ch)
+		close(ch)
+	}()
+
+	values := make(map[string]float64)
+	for metric := range ch {
+		var m dto.Metric
+		if err := metric.Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		labels := make([]string, len(m.GetLabel()))
+		for i, label := range m.GetLabel() {
+			labels[i] = label.GetName() + `="` + label.GetValue() + `"`
+		}
+		key := descName.FindStringSubmatch(metric.Desc().String())[1] + "{" + strings.Join(labels, ",") + "}"
+		switch {
+		case m.Gauge != nil:
+			values[key] = m.GetGauge().GetValue()
+		case m.Counter != nil:
+			values[key] = m.GetCounter().GetValue()
+		case m.Histogram != nil:
+			values[key] = float64(m.GetHistogram().GetSampleCount())
+		}
+	}
+	return values, <-errs
+}
+
+func scrapValues(t *testing.T, e *QueryMetricsExporter, clickConn clickhouse.ClickhouseConn) (map[string]float64, error) {
+	t.Helper()
+	return collectSamples(t, func(ch chan<- prometheus.Metric) error {
+		return e.Scrap(context.Background(), clickConn, ch)
+	})
+}
+
+func TestQueryMetricsIncremental(t *testing.T) {
+	fake := &fakeQueryLog{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	uri, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clickConn, err := clickhouse.NewClickhouseConn(*uri, "", "", nil, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := readYamlString(t, "query_exporter:\n  incremental: true\n  lag: 2s\n  duration_buckets: [1]\n")
+	e := NewQueryMetricsExporter(*uri, "clickhouse", config.GetMapObject("query_exporter"), "")
+
+	totals := e.incremental
+	if !totals.watermark.Equal(totals.watermark.Truncate(time.Second)) {
+		t.Fatalf("initial watermark %v is not a whole second", totals.watermark)
+	}
+
+	const (
+		queryNum  = `clickhouse_user_query_num_total{kind="Select",table="db.events",type="QueryFinish",user="alice"}`
+		readBytes = `clickhouse_user_read_bytes_total{kind="Select",table="db.events",type="QueryFinish",user="alice"}`
+		durations = `clickhouse_query_duration_seconds{kind="Select",table="db.events",user="alice"}`
+	)
+
+	// the first scrape reads from the watermark up to lag ago, both queries over
+	// the same window
+	since := time.Now().Add(-time.Hour).Truncate(time.Second)
+	totals.watermark = since
+	before := time.Now()
+	values, err := scrapValues(t, &e, clickConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := fake.takeRequests()
+	if len(requests) != 2 || requests[0].duration || !requests[1].duration {
+		t.Fatalf("got requests %+v, want the query and the duration query", requests)
+	}
+	until := requests[0].until
+	if requests[0].since != since.Unix() || requests[1].since != since.Unix() || requests[1].until != until {
+		t.Errorf("got windows %+v, want both from %d to the same until", requests, since.Unix())
+	}
+	if lowest, highest := before.Add(-e.Lag).Unix()-1, time.Now().Add(-e.Lag).Unix(); until < lowest || until > highest {
+		t.Errorf("until %d is not lag ago, want between %d and %d", until, lowest, highest)
+	}
+	if !totals.watermark.Equal(time.Unix(until, 0)) {
+		t.Errorf("watermark %v, want %v", totals.watermark, time.Unix(until, 0))
+	}
+	if values[queryNum] != 2 || values[readBytes] != 10 || values[durations] != 2 {
+		t.Errorf("got values %v after the first scrape", values)
+	}
+
+	// a watermark younger than lag leaves no window to read, the totals are
+	// exported unchanged
+	totals.watermark = time.Now().Add(time.Hour).Truncate(time.Second)
+	values, err = scrapValues(t, &e, clickConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests := fake.takeRequests(); len(requests) != 0 {
+		t.Errorf("got requests %+v, want none with an empty window", requests)
+	}
+	if values[queryNum] != 2 {
+		t.Errorf("got values %v without a window to read", values)
+	}
+
+	// rewind the watermark so each following scrape has a window to read
+	rewound := time.Unix(until, 0).Add(-time.Minute)
+	for _, failing := range []struct {
+		name    string
+		failing func(duration bool) bool
+	}{
+		{"query", func(duration bool) bool { return !duration }},
+		{"duration query", func(duration bool) bool { return duration }},
+	} {
+		totals.watermark = rewound
+		fake.setFailing(failing.failing)
+		if _, err := scrapValues(t, &e, clickConn); err == nil {
+			t.Fatalf("scrape succeeded with a failing %s", failing.name)
+		}
+		for _, request := range fake.takeRequests() {
+			if request.since != rewound.Unix() {
+				t.Errorf("failing %s: got window %+v, want it to start at %d", failing.name, request, rewound.Unix())
+			}
+		}
+		if !totals.watermark.Equal(rewound) {
+			t.Errorf("failing %s moved the watermark to %v", failing.name, totals.watermark)
+		}
+		if series := totals.series[strings.Join([]string{"alice", "db.events", "QueryFinish", "Select"}, "\x00")]; series.values[0] != 2 {
+			t.Errorf("failing %s changed the query count to %v", failing.name, series.values[0])
+		}
+		if histogram := totals.durations[strings.Join([]string{"alice", "db.events", "Select"}, "\x00")]; histogram.count != 2 {
+			t.Errorf("failing %s changed the duration count to %v", failing.name, histogram.count)
+		}
+	}
+
+	// the next scrape reads again the window of the failed ones
+	fake.setFailing(nil)
+	values, err = scrapValues(t, &e, clickConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, request := range fake.takeRequests() {
+		if request.since != rewound.Unix() {
+			t.Errorf("got window %+v after the failures, want it to start at %d", request, rewound.Unix())
+		}
+	}
+	if values[queryNum] != 4 || values[readBytes] != 20 || values[durations] != 4 {
+		t.Errorf("got values %v after the second window", values)
+	}
+}
+
+func TestQueryLogFilterClause(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     string
+		conditions []string
+		want       string
+	}{
+		{"no filters", "query_exporter: {}\n", nil, ""},
+		{"conditions only", "query_exporter: {}\n", []string{QUERY_DURATION_FILTER}, "WHERE\n" + QUERY_DURATION_FILTER},
+		{
+			"or filter", "query_exporter:\n  filters: user = 'a' OR user = 'b'\n", []string{QUERY_DURATION_FILTER},
+			"WHERE\n(user = 'a' OR user = 'b') AND\n" + QUERY_DURATION_FILTER,
+		},
+		{
+			"filter list", "query_exporter:\n  filters:\n    - user = 'a'\n    - is_initial_query\n",
+			[]string{QUERY_LOG_WINDOW_FILTER, QUERY_DURATION_FILTER},
+			"WHERE\n(user = 'a' AND\nis_initial_query) AND\n" + QUERY_LOG_WINDOW_FILTER + " AND\n" + QUERY_DURATION_FILTER,
+		},
+		{"filters only", "query_exporter:\n  filters: user = 'a' OR user = 'b'\n", nil, "WHERE\n(user = 'a' OR user = 'b')"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := readYamlString(t, tt.config)
+			if got := queryLogFilterClause(config.GetMapObject("query_exporter"), tt.conditions...); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package exporters
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/ClickHouse/clickhouse_exporter/internals/util"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/clickhouse"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/queryparser"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/yaml"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// log_max_index, log_pointer, total_replicas and active_replicas are read
+	// from ZooKeeper, one request per replicated table
+	REPLICAS_METRIC_EXPORTER_QUERY = `
+	select
+		database, table,
+		is_readonly, is_session_expired, absolute_delay,
+		queue_size, inserts_in_queue, merges_in_queue,
+		greatest(toInt64(log_max_index) - toInt64(log_pointer), 0) as log_pointer_lag,
+		active_replicas, total_replicas {CLUSTER_COLUMNS}
+	from system.replicas
+	{FILTER_CLAUSE}`
+)
+
+func init() {
+	RegisterCollector("replicas_exporter", func(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) Collector {
+		exporter := NewReplicasMetricsExporter(uri, namespace, yamlconfig, cluster)
+		return &exporter
+	})
+}
+
+type ReplicasMetricsExporter struct {
+	Namespace string
+	QueryURI  string
+
+	ClusterLabels util.ClusterLabels
+}
+
+func NewReplicasMetricsExporter(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) ReplicasMetricsExporter {
+
+	query := queryparser.BuildQuery(REPLICAS_METRIC_EXPORTER_QUERY, yamlconfig, cluster)
+	log.Printf("replicas exporter query: %v", query)
+
+	url_values := uri.Query()
+	metricsURI := uri
+	url_values.Set("query", query)
+	metricsURI.RawQuery = url_values.Encode()
+
+	return ReplicasMetricsExporter{
+		QueryURI:      metricsURI.String(),
+		Namespace:     namespace,
+		ClusterLabels: util.NewClusterLabels(cluster),
+	}
+}
+
+func (e *ReplicasMetricsExporter) Scrap(ctx context.Context, clickConn clickhouse.ClickhouseConn, ch chan<- prometheus.Metric) error {
+	replicas, err := e.parseResponse(ctx, clickConn)
+	if err != nil {
+		return fmt.Errorf("error scraping clickhouse url %v: %v", e.QueryURI, err)
+	}
+	e.collect(replicas, ch)
+	return nil
+}
+
+type ReplicasResult struct {
+	Database         string `ch:"database"`
+	Table            string `ch:"table"`
+	IsReadonly       int    `ch:"is_readonly"`
+	IsSessionExpired int    `ch:"is_session_expired"`
+	AbsoluteDelay    int    `ch:"absolute_delay"`
+	QueueSize        int    `ch:"queue_size"`
+	InsertsInQueue   int    `ch:"inserts_in_queue"`
+	MergesInQueue    int    `ch:"merges_in_queue"`
+	LogPointerLag    int    `ch:"log_pointer_lag"`
+	ActiveReplicas   int    `ch:"active_replicas"`
+	TotalReplicas    int    `ch:"total_replicas"`
+	util.NodeLabels
+}
+
+func (e *ReplicasMetricsExporter) parseResponse(ctx context.Context, clickConn clickhouse.ClickhouseConn) ([]ReplicasResult, error) {
+	result, err := clickConn.Query(ctx, e.QueryURI)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []ReplicasResult
+	if err := result.Decode(&results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (e *ReplicasMetricsExporter) collect(resultLines []ReplicasResult, ch chan<- prometheus.Metric) {
+	metric_label := e.ClusterLabels.Names("database", "table")
+
+	for _, replica := range resultLines {
+		label_values := e.ClusterLabels.Values(replica.NodeLabels, replica.Database, replica.Table)
+
+		newReadonlyMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "replica_is_readonly",
+			Help:      "Is the replica in read-only mode",
+		}, metric_label).WithLabelValues(label_values...)
+		newReadonlyMetric.Set(float64(replica.IsReadonly))
+		newReadonlyMetric.Collect(ch)
+
+		newSessionExpiredMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "replica_is_session_expired",
+			Help:      "Has the session with ZooKeeper expired",
+		}, metric_label).WithLabelValues(label_values...)
+		newSessionExpiredMetric.Set(float64(replica.IsSessionExpired))
+		newSessionExpiredMetric.Collect(ch)
+
+		newAbsoluteDelayMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "replica_absolute_delay_seconds",
+			Help:      "How far behind the replica is in seconds",
+		}, metric_label).WithLabelValues(label_values...)
+		newAbsoluteDelayMetric.Set(float64(replica.AbsoluteDelay))
+		newAbsoluteDelayMetric.Collect(ch)
+
+		newQueueSizeMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "replica_queue_size",
+			Help:      "Number of operations waiting in the replication queue",
+		}, metric_label).WithLabelValues(label_values...)
+		newQueueSizeMetric.Set(float64(replica.QueueSize))
+		newQueueSizeMetric.Collect(ch)
+
+		newInsertsInQueueMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "replica_inserts_in_queue",
+			Help:      "Number of inserts of blocks of data waiting in the replication queue",
+		}, metric_label).WithLabelValues(label_values...)
+		newInsertsInQueueMetric.Set(float64(replica.InsertsInQueue))
+		newInsertsInQueueMetric.Collect(ch)
+
+		newMergesInQueueMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "replica_merges_in_queue",
+			Help:      "Number of merges waiting in the replication queue",
+		}, metric_label).WithLabelValues(label_values...)
+		newMergesInQueueMetric.Set(float64(replica.MergesInQueue))
+		newMergesInQueueMetric.Collect(ch)
+
+		newLogPointerLagMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "replica_log_pointer_lag",
+			Help:      "Number of entries of the replication log not yet copied to the queue of the replica",
+		}, metric_label).WithLabelValues(label_values...)
+		newLogPointerLagMetric.Set(float64(replica.LogPointerLag))
+		newLogPointerLagMetric.Collect(ch)
+
+		newActiveReplicasMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "replica_active_replicas",
+			Help:      "Number of replicas of the table with a session in ZooKeeper",
+		}, metric_label).WithLabelValues(label_values...)
+		newActiveReplicasMetric.Set(float64(replica.ActiveReplicas))
+		newActiveReplicasMetric.Collect(ch)
+
+		newTotalReplicasMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "replica_total_replicas",
+			Help:      "Number of known replicas of the table",
+		}, metric_label).WithLabelValues(label_values...)
+		newTotalReplicasMetric.Set(float64(replica.TotalReplicas))
+		newTotalReplicasMetric.Collect(ch)
+	}
+
+}
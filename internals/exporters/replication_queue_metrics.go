@@ -0,0 +1,133 @@
+package exporters
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/ClickHouse/clickhouse_exporter/internals/util"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/clickhouse"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/queryparser"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/yaml"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	REPLICATION_QUEUE_METRIC_EXPORTER_QUERY = `
+	select
+		database, table, type,
+		count() as entries,
+		dateDiff('second', min(create_time), now()) as oldest_entry_age,
+		max(num_tries) as max_num_tries,
+		countIf(last_exception != '') as entries_with_exception {CLUSTER_COLUMNS}
+	from system.replication_queue
+	{FILTER_CLAUSE}
+	group by database, table, type {CLUSTER_GROUP_BY}`
+)
+
+func init() {
+	RegisterCollector("replication_queue_exporter", func(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) Collector {
+		exporter := NewReplicationQueueMetricsExporter(uri, namespace, yamlconfig, cluster)
+		return &exporter
+	})
+}
+
+type ReplicationQueueMetricsExporter struct {
+	Namespace string
+	QueryURI  string
+
+	ClusterLabels util.ClusterLabels
+}
+
+func NewReplicationQueueMetricsExporter(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) ReplicationQueueMetricsExporter {
+
+	query := queryparser.BuildQuery(REPLICATION_QUEUE_METRIC_EXPORTER_QUERY, yamlconfig, cluster)
+	log.Printf("replication queue exporter query: %v", query)
+
+	url_values := uri.Query()
+	metricsURI := uri
+	url_values.Set("query", query)
+	metricsURI.RawQuery = url_values.Encode()
+
+	return ReplicationQueueMetricsExporter{
+		QueryURI:      metricsURI.String(),
+		Namespace:     namespace,
+		ClusterLabels: util.NewClusterLabels(cluster),
+	}
+}
+
+func (e *ReplicationQueueMetricsExporter) Scrap(ctx context.Context, clickConn clickhouse.ClickhouseConn, ch chan<- prometheus.Metric) error {
+	entries, err := e.parseResponse(ctx, clickConn)
+	if err != nil {
+		return fmt.Errorf("error scraping clickhouse url %v: %v", e.QueryURI, err)
+	}
+	e.collect(entries, ch)
+	return nil
+}
+
+type ReplicationQueueResult struct {
+	Database             string `ch:"database"`
+	Table                string `ch:"table"`
+	Type                 string `ch:"type"`
+	Entries              int    `ch:"entries"`
+	OldestEntryAge       int    `ch:"oldest_entry_age"`
+	MaxNumTries          int    `ch:"max_num_tries"`
+	EntriesWithException int    `ch:"entries_with_exception"`
+	util.NodeLabels
+}
+
+func (e *ReplicationQueueMetricsExporter) parseResponse(ctx context.Context, clickConn clickhouse.ClickhouseConn) ([]ReplicationQueueResult, error) {
+	result, err := clickConn.Query(ctx, e.QueryURI)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []ReplicationQueueResult
+	if err := result.Decode(&results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (e *ReplicationQueueMetricsExporter) collect(resultLines []ReplicationQueueResult, ch chan<- prometheus.Metric) {
+	metric_label := e.ClusterLabels.Names("database", "table", "type")
+
+	for _, entry := range resultLines {
+		label_values := e.ClusterLabels.Values(entry.NodeLabels, entry.Database, entry.Table, entry.Type)
+
+		newEntriesMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "replication_queue_entries",
+			Help:      "Number of entries of this type in the replication queue",
+		}, metric_label).WithLabelValues(label_values...)
+		newEntriesMetric.Set(float64(entry.Entries))
+		newEntriesMetric.Collect(ch)
+
+		newOldestEntryAgeMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "replication_queue_oldest_entry_age_seconds",
+			Help:      "Seconds since the oldest entry of this type was added to the replication queue",
+		}, metric_label).WithLabelValues(label_values...)
+		newOldestEntryAgeMetric.Set(float64(entry.OldestEntryAge))
+		newOldestEntryAgeMetric.Collect(ch)
+
+		newMaxNumTriesMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "replication_queue_max_num_tries",
+			Help:      "Largest number of attempts made at an entry of this type",
+		}, metric_label).WithLabelValues(label_values...)
+		newMaxNumTriesMetric.Set(float64(entry.MaxNumTries))
+		newMaxNumTriesMetric.Collect(ch)
+
+		newEntriesWithExceptionMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "replication_queue_entries_with_exception",
+			Help:      "Number of entries of this type whose last attempt failed",
+		}, metric_label).WithLabelValues(label_values...)
+		newEntriesWithExceptionMetric.Set(float64(entry.EntriesWithException))
+		newEntriesWithExceptionMetric.Collect(ch)
+	}
+
+}
@@ -1,11 +1,11 @@
 package exporters
 
 import (
+	"context"
 	"fmt"
 	"net/url"
-	"strconv"
-	"strings"
 
+	"github.com/ClickHouse/clickhouse_exporter/internals/util"
 	"github.com/ClickHouse/clickhouse_exporter/pkg/clickhouse"
 	"github.com/ClickHouse/clickhouse_exporter/pkg/queryparser"
 	"github.com/ClickHouse/clickhouse_exporter/pkg/yaml"
@@ -15,18 +15,26 @@ import (
 )
 
 const (
-	TABLE_METRIC_EXPORTER_QUERY = `select database, name as table, engine, total_rows, total_bytes, parts from system.tables {FILTER_CLAUSE}`
+	TABLE_METRIC_EXPORTER_QUERY = `select database, name as table, engine, total_rows, total_bytes, parts {CLUSTER_COLUMNS} from system.tables {FILTER_CLAUSE}`
 )
 
+func init() {
+	RegisterCollector("table_exporter", func(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) Collector {
+		exporter := NewTableMetricsExporter(uri, namespace, yamlconfig, cluster)
+		return &exporter
+	})
+}
+
 type TableMetricsExporter struct {
 	Namespace string
 	QueryURI  string
+
+	ClusterLabels util.ClusterLabels
 }
 
-func NewTableMetricsExporter(uri url.URL, namespace string, yamlconfig yaml.YamlConfig) TableMetricsExporter {
+func NewTableMetricsExporter(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) TableMetricsExporter {
 
-	filter_calause := queryparser.ParseYamlConfigToQueryFilter(yamlconfig)
-	query := strings.Replace(TABLE_METRIC_EXPORTER_QUERY, "{FILTER_CLAUSE}", filter_calause, 1)
+	query := queryparser.BuildQuery(TABLE_METRIC_EXPORTER_QUERY, yamlconfig, cluster)
 	log.Printf("table exporter query: %v", query)
 
 	url_values := uri.Query()
@@ -35,13 +43,14 @@ func NewTableMetricsExporter(uri url.URL, namespace string, yamlconfig yaml.Yaml
 	metricsURI.RawQuery = url_values.Encode()
 
 	return TableMetricsExporter{
-		QueryURI:  metricsURI.String(),
-		Namespace: namespace,
+		QueryURI:      metricsURI.String(),
+		Namespace:     namespace,
+		ClusterLabels: util.NewClusterLabels(cluster),
 	}
 }
 
-func (e *TableMetricsExporter) Scrap(clickConn clickhouse.ClickhouseConn, ch chan<- prometheus.Metric) error {
-	table_metrics, err := e.parseResponse(clickConn)
+func (e *TableMetricsExporter) Scrap(ctx context.Context, clickConn clickhouse.ClickhouseConn, ch chan<- prometheus.Metric) error {
+	table_metrics, err := e.parseResponse(ctx, clickConn)
 	if err != nil {
 		return fmt.Errorf("error scraping clickhouse url %v: %v", e.QueryURI, err)
 	}
@@ -51,93 +60,57 @@ func (e *TableMetricsExporter) Scrap(clickConn clickhouse.ClickhouseConn, ch cha
 }
 
 type TableMetricsResult struct {
-	database    string
-	table       string
-	engine      string
-	total_rows  int
-	total_bytes int
-	parts       int
+	Database   string `ch:"database"`
+	Table      string `ch:"table"`
+	Engine     string `ch:"engine"`
+	TotalRows  int    `ch:"total_rows"`
+	TotalBytes int    `ch:"total_bytes"`
+	Parts      int    `ch:"parts"`
+	util.NodeLabels
 }
 
-func (e *TableMetricsExporter) parseResponse(clickConn clickhouse.ClickhouseConn) ([]TableMetricsResult, error) {
-	data, err := clickConn.ExcecuteQuery(e.QueryURI)
+func (e *TableMetricsExporter) parseResponse(ctx context.Context, clickConn clickhouse.ClickhouseConn) ([]TableMetricsResult, error) {
+	result, err := clickConn.Query(ctx, e.QueryURI)
 	if err != nil {
 		return nil, err
 	}
 
-	// Parsing results
-	lines := strings.Split(string(data), "\n")
-	var results = make([]TableMetricsResult, 0)
-
-	for i, line := range lines {
-		fields := strings.Fields(line)
-		if len(fields) == 0 {
-			continue
-		}
-		if len(fields) != 6 {
-			return nil, fmt.Errorf("parseQueryResponse: unexpected %d line: %s", i, line)
-		}
-		database := strings.TrimSpace(fields[0])
-		table := strings.TrimSpace(fields[1])
-		engine := strings.TrimSpace(fields[2])
-
-		total_rows, err := strconv.Atoi(strings.TrimSpace(fields[3]))
-		if err != nil {
-			return nil, err
-		}
-
-		total_bytes, err := strconv.Atoi(strings.TrimSpace(fields[4]))
-		if err != nil {
-			return nil, err
-		}
-
-		parts, err := strconv.Atoi(strings.TrimSpace(fields[5]))
-		if err != nil {
-			return nil, err
-		}
-
-		results = append(results, TableMetricsResult{
-			database:    database,
-			table:       table,
-			engine:      engine,
-			total_rows:  total_rows,
-			total_bytes: total_bytes,
-			parts:       parts,
-		})
+	var results []TableMetricsResult
+	if err := result.Decode(&results); err != nil {
+		return nil, err
 	}
-
 	return results, nil
-
 }
 
 func (e *TableMetricsExporter) collect(resultLines []TableMetricsResult, ch chan<- prometheus.Metric) {
 
 	for _, query_metrics := range resultLines {
 
-		metric_label := []string{"database", "table", "engine"}
+		metric_label := e.ClusterLabels.Names("database", "table", "engine")
+		label_values := e.ClusterLabels.Values(query_metrics.NodeLabels, query_metrics.Database, query_metrics.Table, query_metrics.Engine)
 
 		newTotalRows := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: e.Namespace,
 			Name:      "table_rows",
 			Help:      "number of rows of a table",
-		}, metric_label).WithLabelValues(query_metrics.database, query_metrics.table, query_metrics.engine)
-		newTotalRows.Set(float64(query_metrics.total_rows))
+		}, metric_label).WithLabelValues(label_values...)
+		newTotalRows.Set(float64(query_metrics.TotalRows))
 		newTotalRows.Collect(ch)
 
 		newTotalBytes := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: e.Namespace,
 			Name:      "table_bytes",
 			Help:      "table compressed bytes volume",
-		}, metric_label).WithLabelValues(query_metrics.database, query_metrics.table, query_metrics.engine)
-		newTotalBytes.Set(float64(query_metrics.total_bytes))
+		}, metric_label).WithLabelValues(label_values...)
+		newTotalBytes.Set(float64(query_metrics.TotalBytes))
 		newTotalBytes.Collect(ch)
 
 		newParts := prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Namespace: e.Namespace,
 			Name:      "table_parts",
 			Help:      "number of current table partitions",
-		}, metric_label).WithLabelValues(query_metrics.database, query_metrics.table, query_metrics.engine)
-		newParts.Set(float64(query_metrics.parts))
+		}, metric_label).WithLabelValues(label_values...)
+		newParts.Set(float64(query_metrics.Parts))
 		newParts.Collect(ch)
 	}
 
@@ -0,0 +1,169 @@
+package exporters
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/ClickHouse/clickhouse_exporter/internals/util"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/clickhouse"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/queryparser"
+	"github.com/ClickHouse/clickhouse_exporter/pkg/yaml"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	ZOOKEEPER_METRIC_EXPORTER_QUERY = `
+	select
+		name, host, toString(port) as port,
+		toUnixTimestamp(connected_time) as connected_time,
+		session_uptime_elapsed_seconds, is_expired, keeper_api_version {CLUSTER_COLUMNS}
+	from system.zookeeper_connection
+	{FILTER_CLAUSE}`
+
+	// the filters of the exporter only apply to the connections
+	ZOOKEEPER_EVENTS_QUERY = `select event, value {CLUSTER_COLUMNS} from system.events where event like 'ZooKeeper%'`
+
+	// connection the events of a node are attributed to
+	DEFAULT_ZOOKEEPER_NAME = "default"
+)
+
+func init() {
+	RegisterCollector("zookeeper_exporter", func(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) Collector {
+		exporter := NewZookeeperMetricsExporter(uri, namespace, yamlconfig, cluster)
+		return &exporter
+	})
+}
+
+type ZookeeperMetricsExporter struct {
+	Namespace string
+	QueryURI  string
+	EventsURI string
+
+	ClusterLabels util.ClusterLabels
+}
+
+func NewZookeeperMetricsExporter(uri url.URL, namespace string, yamlconfig yaml.YamlConfig, cluster string) ZookeeperMetricsExporter {
+
+	query := queryparser.BuildQuery(ZOOKEEPER_METRIC_EXPORTER_QUERY, yamlconfig, cluster)
+	log.Printf("zookeeper exporter query: %v", query)
+	eventsQuery := queryparser.BuildQuery(ZOOKEEPER_EVENTS_QUERY, yamlconfig, cluster)
+	log.Printf("zookeeper exporter events query: %v", eventsQuery)
+
+	url_values := uri.Query()
+	metricsURI := uri
+	url_values.Set("query", query)
+	metricsURI.RawQuery = url_values.Encode()
+
+	eventsURI := uri
+	url_values.Set("query", eventsQuery)
+	eventsURI.RawQuery = url_values.Encode()
+
+	return ZookeeperMetricsExporter{
+		QueryURI:      metricsURI.String(),
+		EventsURI:     eventsURI.String(),
+		Namespace:     namespace,
+		ClusterLabels: util.NewClusterLabels(cluster),
+	}
+}
+
+func (e *ZookeeperMetricsExporter) Scrap(ctx context.Context, clickConn clickhouse.ClickhouseConn, ch chan<- prometheus.Metric) error {
+	connections, err := e.parseResponse(ctx, clickConn)
+	if err != nil {
+		return fmt.Errorf("error scraping clickhouse url %v: %v", e.QueryURI, err)
+	}
+	events, err := util.ParseKeyValueResponse(ctx, e.EventsURI, clickConn)
+	if err != nil {
+		return fmt.Errorf("error scraping clickhouse url %v: %v", e.EventsURI, err)
+	}
+	e.collect(connections, events, ch)
+	return nil
+}
+
+type ZookeeperConnectionResult struct {
+	Name                 string `ch:"name"`
+	Host                 string `ch:"host"`
+	Port                 string `ch:"port"`
+	ConnectedTime        int    `ch:"connected_time"`
+	SessionUptimeSeconds int    `ch:"session_uptime_elapsed_seconds"`
+	IsExpired            int    `ch:"is_expired"`
+	KeeperAPIVersion     int    `ch:"keeper_api_version"`
+	util.NodeLabels
+}
+
+func (c ZookeeperConnectionResult) keeperHost() string {
+	return net.JoinHostPort(c.Host, c.Port)
+}
+
+func (e *ZookeeperMetricsExporter) parseResponse(ctx context.Context, clickConn clickhouse.ClickhouseConn) ([]ZookeeperConnectionResult, error) {
+	result, err := clickConn.Query(ctx, e.QueryURI)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []ZookeeperConnectionResult
+	if err := result.Decode(&results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (e *ZookeeperMetricsExporter) collect(connections []ZookeeperConnectionResult, events []util.LineResult, ch chan<- prometheus.Metric) {
+	metric_label := e.ClusterLabels.Names("name", "keeper_host")
+
+	// keeper each node talks to through its default connection
+	keeperHosts := make(map[util.NodeLabels]string)
+
+	for _, connection := range connections {
+		label_values := e.ClusterLabels.Values(connection.NodeLabels, connection.Name, connection.keeperHost())
+		if connection.Name == DEFAULT_ZOOKEEPER_NAME {
+			keeperHosts[connection.NodeLabels] = connection.keeperHost()
+		}
+
+		newConnectedTimeMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "zookeeper_connected_timestamp_seconds",
+			Help:      "Unix time the connection to the keeper was established",
+		}, metric_label).WithLabelValues(label_values...)
+		newConnectedTimeMetric.Set(float64(connection.ConnectedTime))
+		newConnectedTimeMetric.Collect(ch)
+
+		newSessionUptimeMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "zookeeper_session_uptime_seconds",
+			Help:      "Seconds since the session with the keeper started",
+		}, metric_label).WithLabelValues(label_values...)
+		newSessionUptimeMetric.Set(float64(connection.SessionUptimeSeconds))
+		newSessionUptimeMetric.Collect(ch)
+
+		newIsExpiredMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "zookeeper_session_is_expired",
+			Help:      "Has the session with the keeper expired",
+		}, metric_label).WithLabelValues(label_values...)
+		newIsExpiredMetric.Set(float64(connection.IsExpired))
+		newIsExpiredMetric.Collect(ch)
+
+		newAPIVersionMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: e.Namespace,
+			Name:      "zookeeper_keeper_api_version",
+			Help:      "Version of the keeper API spoken on the connection",
+		}, metric_label).WithLabelValues(label_values...)
+		newAPIVersionMetric.Set(float64(connection.KeeperAPIVersion))
+		newAPIVersionMetric.Collect(ch)
+	}
+
+	// events are counted by the server, they are labeled with the keeper it is
+	// connected to, none while the connection is down
+	eventsDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(e.Namespace, "", "zookeeper_events_total"),
+		"Number of ZooKeeper events of the server",
+		e.ClusterLabels.Names("event", "keeper_host"), nil)
+	for _, ev := range events {
+		ch <- prometheus.MustNewConstMetric(eventsDesc, prometheus.CounterValue, ev.Value,
+			e.ClusterLabels.Values(ev.NodeLabels, ev.Key, keeperHosts[ev.NodeLabels])...)
+	}
+}
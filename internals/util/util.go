@@ -1,6 +1,7 @@
 package util
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"strings"
@@ -12,6 +13,41 @@ import (
 type LineResult struct {
 	Key   string
 	Value float64
+	NodeLabels
+}
+
+// NodeLabels are the columns identifying the node a row comes from when a whole
+// cluster is scraped, see queryparser.BuildQuery. They are empty otherwise.
+type NodeLabels struct {
+	Hostname string `ch:"hostname,optional"`
+	Shard    string `ch:"shard,optional"`
+	Replica  string `ch:"replica,optional"`
+}
+
+// ClusterLabels adds the shard, replica and hostname labels to the metrics of a
+// collector when a whole cluster is scraped. Its zero value adds nothing.
+type ClusterLabels struct {
+	Enabled bool
+}
+
+func NewClusterLabels(cluster string) ClusterLabels {
+	return ClusterLabels{Enabled: cluster != ""}
+}
+
+// Names returns the given label names followed by the node label names.
+func (c ClusterLabels) Names(names ...string) []string {
+	if !c.Enabled {
+		return names
+	}
+	return append(names, "shard", "replica", "hostname")
+}
+
+// Values returns the given label values followed by the labels of node.
+func (c ClusterLabels) Values(node NodeLabels, values ...string) []string {
+	if !c.Enabled {
+		return values
+	}
+	return append(values, node.Shard, node.Replica, node.Hostname)
 }
 
 func GetMetricName(Key string) string {
@@ -28,31 +64,30 @@ func ParseNumber(s string) (float64, error) {
 	return v, nil
 }
 
-func ParseKeyValueResponse(uri string, clickConn clickhouse.ClickhouseConn) ([]LineResult, error) {
-	data, err := clickConn.ExcecuteQuery(uri)
+// ParseKeyValueResponse runs a query returning two columns, a name and a
+// numeric value, followed by the node columns in cluster mode, and returns its
+// rows.
+func ParseKeyValueResponse(ctx context.Context, uri string, clickConn clickhouse.ClickhouseConn) ([]LineResult, error) {
+	result, err := clickConn.Query(ctx, uri)
 	if err != nil {
 		return nil, err
 	}
+	if len(result.Columns) < 2 {
+		return nil, fmt.Errorf("parseKeyValueResponse: expected 2 columns, got %d", len(result.Columns))
+	}
 
-	// Parsing results
-	lines := strings.Split(string(data), "\n")
-	var results = make([]LineResult, 0)
+	var nodes []NodeLabels
+	if err := result.Decode(&nodes); err != nil {
+		return nil, err
+	}
 
-	for i, line := range lines {
-		parts := strings.Fields(line)
-		if len(parts) == 0 {
-			continue
-		}
-		if len(parts) != 2 {
-			return nil, fmt.Errorf("parseKeyValueResponse: unexpected %d line: %s", i, line)
-		}
-		k := strings.TrimSpace(parts[0])
-		v, err := ParseNumber(strings.TrimSpace(parts[1]))
+	var results = make([]LineResult, 0, len(result.Rows))
+	for i, row := range result.Rows {
+		v, err := ParseNumber(row[1])
 		if err != nil {
 			return nil, err
 		}
-		results = append(results, LineResult{k, v})
-
+		results = append(results, LineResult{Key: row[0], Value: v, NodeLabels: nodes[i]})
 	}
 	return results, nil
 }
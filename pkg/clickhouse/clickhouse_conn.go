@@ -1,45 +1,90 @@
 package clickhouse
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
-	"io"
 	"net/http"
-
-	"github.com/rs/zerolog/log"
+	"net/url"
+	"strconv"
+	"time"
 )
 
+// Transport sends a query uri to clickhouse and returns its parsed result. The
+// uri carries the query and its settings as url parameters, whatever protocol
+// is spoken underneath.
+type Transport interface {
+	Query(ctx context.Context, query string, user string, password string) (*Result, error)
+}
+
 type ClickhouseConn struct {
-	Client   *http.Client
-	User     string
-	Password string
+	Transport Transport
+	User      string
+	Password  string
+
+	// SkipMaxExecutionTime leaves max_execution_time out of the queries, a
+	// readonly=1 user is not allowed to change any setting.
+	SkipMaxExecutionTime bool
 }
 
-func (e *ClickhouseConn) ExcecuteQuery(query string) ([]byte, error) {
-	req, err := http.NewRequest("GET", query, nil)
-	if err != nil {
-		return nil, err
+// NewClickhouseConn picks the transport matching the scheme of the scrape uri:
+// http and https use the HTTP interface, clickhouse and clickhouses (TLS) the
+// native TCP protocol.
+func NewClickhouseConn(uri url.URL, user string, password string, tlsConfig *tls.Config, timeout time.Duration) (ClickhouseConn, error) {
+	conn := ClickhouseConn{User: user, Password: password}
+
+	switch uri.Scheme {
+	case "http", "https":
+		conn.Transport = &HTTPTransport{
+			Client: &http.Client{
+				Transport: &http.Transport{TLSClientConfig: tlsConfig},
+				Timeout:   timeout,
+			},
+		}
+	case "clickhouse":
+		conn.Transport = &NativeTransport{Timeout: timeout}
+	case "clickhouses":
+		conn.Transport = &NativeTransport{TLSConfig: tlsConfig, Timeout: timeout}
+	default:
+		return ClickhouseConn{}, fmt.Errorf("unsupported clickhouse uri scheme %q, use http, https, clickhouse or clickhouses", uri.Scheme)
+	}
+
+	return conn, nil
+}
+
+// Query runs the query uri against clickhouse. When ctx has a deadline, the
+// remaining time is passed to the server as max_execution_time so clickhouse
+// stops working on abandoned queries as well, unless SkipMaxExecutionTime is set.
+func (e *ClickhouseConn) Query(ctx context.Context, query string) (*Result, error) {
+	if !e.SkipMaxExecutionTime {
+		var err error
+		if query, err = withMaxExecutionTime(ctx, query); err != nil {
+			return nil, err
+		}
 	}
-	if e.User != "" && e.Password != "" {
-		req.Header.Set("X-ClickHouse-User", e.User)
-		req.Header.Set("X-ClickHouse-Key", e.Password)
+	return e.Transport.Query(ctx, query, e.User, e.Password)
+}
+
+// withMaxExecutionTime sets the max_execution_time setting of the query uri to
+// the whole seconds left before the deadline of ctx. A lower value already
+// present in the uri is kept.
+func withMaxExecutionTime(ctx context.Context, query string) (string, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return query, nil
 	}
-	resp, err := e.Client.Do(req)
+	seconds := max(int(time.Until(deadline).Seconds()), 1)
+
+	uri, err := url.Parse(query)
 	if err != nil {
-		return nil, fmt.Errorf("error scraping clickhouse: %v", err)
+		return "", err
 	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			log.Error().Err(err).Msg("can't close resp.Body")
-		}
-	}()
-
-	data, err := io.ReadAll(resp.Body)
-	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
-		if err != nil {
-			data = []byte(err.Error())
-		}
-		return nil, fmt.Errorf("status %s (%d): %s", resp.Status, resp.StatusCode, data)
+	url_values := uri.Query()
+	if current, err := strconv.Atoi(url_values.Get("max_execution_time")); err == nil && current > 0 && current <= seconds {
+		return query, nil
 	}
+	url_values.Set("max_execution_time", strconv.Itoa(seconds))
+	uri.RawQuery = url_values.Encode()
 
-	return data, nil
+	return uri.String(), nil
 }
@@ -0,0 +1,90 @@
+package clickhouse
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readonlyServer answers like the HTTP interface of clickhouse for a
+// readonly=1 user, which may not change any setting, and records the
+// max_execution_time of the last query.
+func readonlyServer(t *testing.T, maxExecutionTime *string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*maxExecutionTime = r.URL.Query().Get("max_execution_time")
+		if *maxExecutionTime != "" {
+			http.Error(w, "Code: 164. DB::Exception: Cannot modify 'max_execution_time' setting in readonly mode. (READONLY)", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("value\nUInt8\n1\n"))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClickhouseConnMaxExecutionTime(t *testing.T) {
+	tests := []struct {
+		name     string
+		skip     bool
+		timeout  time.Duration
+		query    url.Values
+		want     string
+		wantFail bool
+	}{
+		{name: "no deadline", want: ""},
+		{name: "deadline", timeout: 5 * time.Second, want: "4", wantFail: true},
+		{name: "lower setting kept", timeout: 5 * time.Second, query: url.Values{"max_execution_time": {"2"}}, want: "2", wantFail: true},
+		{name: "skipped", skip: true, timeout: 5 * time.Second, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var maxExecutionTime string
+			server := readonlyServer(t, &maxExecutionTime)
+			uri, err := url.Parse(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			clickConn, err := NewClickhouseConn(*uri, "", "", nil, 5*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			clickConn.SkipMaxExecutionTime = tt.skip
+
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			query := tt.query
+			if query == nil {
+				query = url.Values{}
+			}
+			query.Set("query", "select 1 AS value")
+			uri.RawQuery = query.Encode()
+
+			result, err := clickConn.Query(ctx, uri.String())
+			if maxExecutionTime != tt.want {
+				t.Errorf("got max_execution_time %q, want %q", maxExecutionTime, tt.want)
+			}
+			if tt.wantFail {
+				if err == nil || !strings.Contains(err.Error(), "readonly mode") {
+					t.Errorf("got error %v, want the readonly server to reject the setting", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Rows) != 1 || result.Rows[0][0] != "1" {
+				t.Errorf("got rows %q", result.Rows)
+			}
+		})
+	}
+}
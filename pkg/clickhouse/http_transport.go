@@ -0,0 +1,65 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/rs/zerolog/log"
+)
+
+// HTTPTransport talks to the HTTP interface of clickhouse, port 8123 by default.
+type HTTPTransport struct {
+	Client *http.Client
+}
+
+// Query runs the query uri in the TabSeparatedWithNamesAndTypes format and
+// returns its parsed result.
+func (t *HTTPTransport) Query(ctx context.Context, query string, user string, password string) (*Result, error) {
+	uri, err := url.Parse(query)
+	if err != nil {
+		return nil, err
+	}
+	url_values := uri.Query()
+	url_values.Set("default_format", ResultFormat)
+	uri.RawQuery = url_values.Encode()
+
+	data, err := t.ExcecuteQuery(ctx, uri.String(), user, password)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTabSeparatedWithNamesAndTypes(data)
+}
+
+// ExcecuteQuery runs the query uri and returns the raw response body.
+func (t *HTTPTransport) ExcecuteQuery(ctx context.Context, query string, user string, password string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	if user != "" && password != "" {
+		req.Header.Set("X-ClickHouse-User", user)
+		req.Header.Set("X-ClickHouse-Key", password)
+	}
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error scraping clickhouse: %v", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Error().Err(err).Msg("can't close resp.Body")
+		}
+	}()
+
+	data, err := io.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		if err != nil {
+			data = []byte(err.Error())
+		}
+		return nil, fmt.Errorf("status %s (%d): %s", resp.Status, resp.StatusCode, data)
+	}
+
+	return data, nil
+}
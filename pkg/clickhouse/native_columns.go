@@ -0,0 +1,515 @@
+package clickhouse
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// columnType decodes the columns of one clickhouse data type in a native block.
+// readPrefix reads the serialization state written once per block before the
+// column data, read decodes the values of the given number of rows to text.
+type columnType interface {
+	readPrefix(r *nativeReader) error
+	read(r *nativeReader, rows int) ([]string, error)
+}
+
+// parseColumnType returns the decoder of a clickhouse type name such as
+// LowCardinality(Nullable(String)). timezone is the server timezone used by
+// DateTime columns without an explicit one.
+func parseColumnType(typeName string, timezone string) (columnType, error) {
+	name, args, err := splitTypeName(typeName)
+	if err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case "UInt8":
+		return fixedColumn{1, func(b []byte) string { return strconv.FormatUint(uint64(b[0]), 10) }}, nil
+	case "UInt16":
+		return fixedColumn{2, func(b []byte) string { return strconv.FormatUint(uint64(binary.LittleEndian.Uint16(b)), 10) }}, nil
+	case "UInt32":
+		return fixedColumn{4, func(b []byte) string { return strconv.FormatUint(uint64(binary.LittleEndian.Uint32(b)), 10) }}, nil
+	case "UInt64":
+		return fixedColumn{8, func(b []byte) string { return strconv.FormatUint(binary.LittleEndian.Uint64(b), 10) }}, nil
+	case "Int8":
+		return fixedColumn{1, func(b []byte) string { return strconv.FormatInt(int64(int8(b[0])), 10) }}, nil
+	case "Int16":
+		return fixedColumn{2, func(b []byte) string { return strconv.FormatInt(int64(int16(binary.LittleEndian.Uint16(b))), 10) }}, nil
+	case "Int32":
+		return fixedColumn{4, func(b []byte) string { return strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(b))), 10) }}, nil
+	case "Int64":
+		return fixedColumn{8, func(b []byte) string { return strconv.FormatInt(int64(binary.LittleEndian.Uint64(b)), 10) }}, nil
+	case "Float32":
+		return fixedColumn{4, func(b []byte) string {
+			return strconv.FormatFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), 'g', -1, 32)
+		}}, nil
+	case "Float64":
+		return fixedColumn{8, func(b []byte) string {
+			return strconv.FormatFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)), 'g', -1, 64)
+		}}, nil
+	case "Bool":
+		return fixedColumn{1, func(b []byte) string { return strconv.FormatBool(b[0] != 0) }}, nil
+	case "Nothing":
+		return fixedColumn{1, func(b []byte) string { return "" }}, nil
+	case "String":
+		return stringColumn{}, nil
+	case "FixedString":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("unexpected type %s", typeName)
+		}
+		size, err := strconv.Atoi(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("unexpected type %s: %w", typeName, err)
+		}
+		return fixedColumn{size, func(b []byte) string { return strings.TrimRight(string(b), "\x00") }}, nil
+	case "UUID":
+		return fixedColumn{16, formatUUID}, nil
+	case "IPv4":
+		return fixedColumn{4, func(b []byte) string {
+			return net.IPv4(b[3], b[2], b[1], b[0]).String()
+		}}, nil
+	case "IPv6":
+		return fixedColumn{16, func(b []byte) string { return net.IP(b).String() }}, nil
+	case "Date":
+		return fixedColumn{2, func(b []byte) string {
+			return time.Unix(int64(binary.LittleEndian.Uint16(b))*86400, 0).UTC().Format(time.DateOnly)
+		}}, nil
+	case "Date32":
+		return fixedColumn{4, func(b []byte) string {
+			return time.Unix(int64(int32(binary.LittleEndian.Uint32(b)))*86400, 0).UTC().Format(time.DateOnly)
+		}}, nil
+	case "DateTime":
+		location := loadLocation(args, 0, timezone)
+		return fixedColumn{4, func(b []byte) string {
+			return time.Unix(int64(binary.LittleEndian.Uint32(b)), 0).In(location).Format(time.DateTime)
+		}}, nil
+	case "DateTime64":
+		if len(args) == 0 {
+			return nil, fmt.Errorf("unexpected type %s", typeName)
+		}
+		precision, err := strconv.Atoi(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("unexpected type %s: %w", typeName, err)
+		}
+		location := loadLocation(args, 1, timezone)
+		return fixedColumn{8, func(b []byte) string {
+			ticks := int64(binary.LittleEndian.Uint64(b))
+			scale := int64(math.Pow10(precision))
+			t := time.Unix(ticks/scale, (ticks%scale)*int64(math.Pow10(9-precision))).In(location)
+			if precision == 0 {
+				return t.Format(time.DateTime)
+			}
+			return t.Format(time.DateTime + "." + strings.Repeat("0", precision))
+		}}, nil
+	case "Decimal", "Decimal32", "Decimal64":
+		return parseDecimal(typeName, name, args)
+	case "Enum8", "Enum16":
+		return parseEnum(typeName, name, args)
+	case "Nullable":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("unexpected type %s", typeName)
+		}
+		nested, err := parseColumnType(args[0], timezone)
+		if err != nil {
+			return nil, err
+		}
+		return nullableColumn{nested}, nil
+	case "LowCardinality":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("unexpected type %s", typeName)
+		}
+		// the dictionary of LowCardinality(Nullable(T)) holds plain T values and
+		// keeps its first entry for NULL
+		dictionaryType, nullable := args[0], false
+		if nestedName, nestedArgs, err := splitTypeName(args[0]); err == nil && nestedName == "Nullable" && len(nestedArgs) == 1 {
+			dictionaryType, nullable = nestedArgs[0], true
+		}
+		dictionary, err := parseColumnType(dictionaryType, timezone)
+		if err != nil {
+			return nil, err
+		}
+		return lowCardinalityColumn{dictionary: dictionary, nullable: nullable}, nil
+	case "Array":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("unexpected type %s", typeName)
+		}
+		nested, err := parseColumnType(args[0], timezone)
+		if err != nil {
+			return nil, err
+		}
+		return arrayColumn{nested: nested, quote: !isNumericType(args[0])}, nil
+	default:
+		return nil, fmt.Errorf("unsupported column type %s", typeName)
+	}
+}
+
+// splitTypeName splits "Name(arg1, arg2)" into its name and top level arguments.
+func splitTypeName(typeName string) (string, []string, error) {
+	typeName = strings.TrimSpace(typeName)
+	open := strings.IndexByte(typeName, '(')
+	if open < 0 {
+		return typeName, nil, nil
+	}
+	if !strings.HasSuffix(typeName, ")") {
+		return "", nil, fmt.Errorf("unexpected type %s", typeName)
+	}
+
+	var args []string
+	depth, quoted, start := 0, false, open+1
+	inner := typeName[:len(typeName)-1]
+	for i := start; i < len(inner); i++ {
+		switch c := inner[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '\'':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			args = append(args, strings.TrimSpace(inner[start:i]))
+			start = i + 1
+		}
+	}
+	args = append(args, strings.TrimSpace(inner[start:]))
+
+	return typeName[:open], args, nil
+}
+
+func isNumericType(typeName string) bool {
+	name, args, err := splitTypeName(typeName)
+	if err != nil {
+		return false
+	}
+	if (name == "Nullable" || name == "LowCardinality") && len(args) == 1 {
+		return isNumericType(args[0])
+	}
+	return strings.HasPrefix(name, "UInt") || strings.HasPrefix(name, "Int") ||
+		strings.HasPrefix(name, "Float") || strings.HasPrefix(name, "Decimal") || name == "Bool"
+}
+
+// unquote reverts the quoting of a string literal in a type name.
+func unquote(s string) string {
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "'"), "'")
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		out.WriteByte(s[i])
+	}
+	return out.String()
+}
+
+func loadLocation(args []string, position int, timezone string) *time.Location {
+	if len(args) > position {
+		timezone = unquote(args[position])
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+func formatUUID(b []byte) string {
+	s := fmt.Sprintf("%016x%016x", binary.LittleEndian.Uint64(b[:8]), binary.LittleEndian.Uint64(b[8:]))
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+func parseDecimal(typeName string, name string, args []string) (columnType, error) {
+	var precision, scale int
+	var err error
+	switch {
+	case name == "Decimal" && len(args) == 2:
+		if precision, err = strconv.Atoi(args[0]); err != nil {
+			return nil, fmt.Errorf("unexpected type %s: %w", typeName, err)
+		}
+		scale, err = strconv.Atoi(args[1])
+	case name == "Decimal32" && len(args) == 1:
+		precision = 9
+		scale, err = strconv.Atoi(args[0])
+	case name == "Decimal64" && len(args) == 1:
+		precision = 18
+		scale, err = strconv.Atoi(args[0])
+	default:
+		return nil, fmt.Errorf("unexpected type %s", typeName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unexpected type %s: %w", typeName, err)
+	}
+
+	switch {
+	case precision <= 9:
+		return fixedColumn{4, func(b []byte) string {
+			return formatDecimal(int64(int32(binary.LittleEndian.Uint32(b))), scale)
+		}}, nil
+	case precision <= 18:
+		return fixedColumn{8, func(b []byte) string {
+			return formatDecimal(int64(binary.LittleEndian.Uint64(b)), scale)
+		}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported column type %s", typeName)
+	}
+}
+
+func formatDecimal(v int64, scale int) string {
+	if scale == 0 {
+		return strconv.FormatInt(v, 10)
+	}
+	sign := ""
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	digits := strconv.FormatInt(v, 10)
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+}
+
+func parseEnum(typeName string, name string, args []string) (columnType, error) {
+	values := make(map[int64]string, len(args))
+	for _, arg := range args {
+		separator := strings.LastIndexByte(arg, '=')
+		if separator < 0 {
+			return nil, fmt.Errorf("unexpected type %s", typeName)
+		}
+		value, err := strconv.ParseInt(strings.TrimSpace(arg[separator+1:]), 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected type %s: %w", typeName, err)
+		}
+		values[value] = unquote(strings.TrimSpace(arg[:separator]))
+	}
+
+	if name == "Enum8" {
+		return fixedColumn{1, func(b []byte) string { return values[int64(int8(b[0]))] }}, nil
+	}
+	return fixedColumn{2, func(b []byte) string { return values[int64(int16(binary.LittleEndian.Uint16(b)))] }}, nil
+}
+
+// fixedColumn decodes the types stored as size bytes per value.
+type fixedColumn struct {
+	size   int
+	format func([]byte) string
+}
+
+func (c fixedColumn) readPrefix(r *nativeReader) error {
+	return nil
+}
+
+func (c fixedColumn) read(r *nativeReader, rows int) ([]string, error) {
+	data, err := r.bytes(c.size * rows)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]string, rows)
+	for i := range values {
+		values[i] = c.format(data[i*c.size : (i+1)*c.size])
+	}
+	return values, nil
+}
+
+type stringColumn struct{}
+
+func (c stringColumn) readPrefix(r *nativeReader) error {
+	return nil
+}
+
+func (c stringColumn) read(r *nativeReader, rows int) ([]string, error) {
+	values := make([]string, rows)
+	for i := range values {
+		var err error
+		if values[i], err = r.string(); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// nullableColumn decodes a map of NULL flags followed by the nested values.
+type nullableColumn struct {
+	nested columnType
+}
+
+func (c nullableColumn) readPrefix(r *nativeReader) error {
+	return c.nested.readPrefix(r)
+}
+
+func (c nullableColumn) read(r *nativeReader, rows int) ([]string, error) {
+	nulls, err := r.bytes(rows)
+	if err != nil {
+		return nil, err
+	}
+	values, err := c.nested.read(r, rows)
+	if err != nil {
+		return nil, err
+	}
+	for i, null := range nulls {
+		if null != 0 {
+			values[i] = ""
+		}
+	}
+	return values, nil
+}
+
+const (
+	lowCardinalitySharedDictionaries = 1
+
+	lowCardinalityKeyTypeMask           = 0xff
+	lowCardinalityNeedGlobalDictionary  = 1 << 8
+	lowCardinalityHasAdditionalKeys     = 1 << 9
+	lowCardinalityKeysUInt8             = 0
+	lowCardinalityKeysUInt16            = 1
+	lowCardinalityKeysUInt32            = 2
+	lowCardinalityKeysUInt64            = 3
+	lowCardinalityMaxDictionaryElements = 1 << 24
+)
+
+// lowCardinalityColumn decodes a dictionary of values followed by the index of
+// each row in it.
+type lowCardinalityColumn struct {
+	dictionary columnType
+	nullable   bool
+}
+
+func (c lowCardinalityColumn) readPrefix(r *nativeReader) error {
+	version, err := r.uint64()
+	if err != nil {
+		return err
+	}
+	if version != lowCardinalitySharedDictionaries {
+		return fmt.Errorf("unsupported LowCardinality serialization version %d", version)
+	}
+	return nil
+}
+
+func (c lowCardinalityColumn) read(r *nativeReader, rows int) ([]string, error) {
+	serialization, err := r.uint64()
+	if err != nil {
+		return nil, err
+	}
+	if serialization&lowCardinalityNeedGlobalDictionary != 0 {
+		return nil, fmt.Errorf("LowCardinality global dictionaries are not supported")
+	}
+
+	var dictionary []string
+	if serialization&lowCardinalityHasAdditionalKeys != 0 {
+		size, err := r.uint64()
+		if err != nil {
+			return nil, err
+		}
+		if size > lowCardinalityMaxDictionaryElements {
+			return nil, fmt.Errorf("LowCardinality dictionary of %d elements is too large", size)
+		}
+		if dictionary, err = c.dictionary.read(r, int(size)); err != nil {
+			return nil, err
+		}
+	}
+
+	numRows, err := r.uint64()
+	if err != nil {
+		return nil, err
+	}
+	if numRows != uint64(rows) {
+		return nil, fmt.Errorf("LowCardinality has %d rows, expected %d", numRows, rows)
+	}
+
+	var keySize int
+	switch serialization & lowCardinalityKeyTypeMask {
+	case lowCardinalityKeysUInt8:
+		keySize = 1
+	case lowCardinalityKeysUInt16:
+		keySize = 2
+	case lowCardinalityKeysUInt32:
+		keySize = 4
+	case lowCardinalityKeysUInt64:
+		keySize = 8
+	default:
+		return nil, fmt.Errorf("unknown LowCardinality key type %d", serialization&lowCardinalityKeyTypeMask)
+	}
+	keys, err := r.bytes(keySize * rows)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]string, rows)
+	for i := range values {
+		var key uint64
+		switch keySize {
+		case 1:
+			key = uint64(keys[i])
+		case 2:
+			key = uint64(binary.LittleEndian.Uint16(keys[i*2:]))
+		case 4:
+			key = uint64(binary.LittleEndian.Uint32(keys[i*4:]))
+		case 8:
+			key = binary.LittleEndian.Uint64(keys[i*8:])
+		}
+		if key >= uint64(len(dictionary)) {
+			return nil, fmt.Errorf("LowCardinality key %d is out of its dictionary of %d elements", key, len(dictionary))
+		}
+		if !(c.nullable && key == 0) {
+			values[i] = dictionary[key]
+		}
+	}
+	return values, nil
+}
+
+// arrayColumn decodes the end offset of every array followed by the values of
+// all arrays, and formats each array the way the text formats do.
+type arrayColumn struct {
+	nested columnType
+	quote  bool
+}
+
+func (c arrayColumn) readPrefix(r *nativeReader) error {
+	return c.nested.readPrefix(r)
+}
+
+func (c arrayColumn) read(r *nativeReader, rows int) ([]string, error) {
+	offsets := make([]uint64, rows)
+	for i := range offsets {
+		var err error
+		if offsets[i], err = r.uint64(); err != nil {
+			return nil, err
+		}
+	}
+
+	var total uint64
+	if rows > 0 {
+		total = offsets[rows-1]
+	}
+	if total > math.MaxInt32 {
+		return nil, fmt.Errorf("arrays of %d elements are too large", total)
+	}
+	items, err := c.nested.read(r, int(total))
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]string, rows)
+	var start uint64
+	for i, end := range offsets {
+		if end < start || end > total {
+			return nil, fmt.Errorf("invalid array offset %d", end)
+		}
+		elements := make([]string, 0, end-start)
+		for _, item := range items[start:end] {
+			if c.quote {
+				item = "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(item) + "'"
+			}
+			elements = append(elements, item)
+		}
+		values[i] = "[" + strings.Join(elements, ",") + "]"
+		start = end
+	}
+	return values, nil
+}
@@ -0,0 +1,177 @@
+package clickhouse
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// columnData builds the native serialization of a column.
+type columnData struct {
+	nativeWriter
+}
+
+func (w *columnData) uint16(v uint16) *columnData {
+	w.buf.Write(binary.LittleEndian.AppendUint16(nil, v))
+	return w
+}
+
+func (w *columnData) uint32(v uint32) *columnData {
+	w.buf.Write(binary.LittleEndian.AppendUint32(nil, v))
+	return w
+}
+
+func (w *columnData) uint64(v uint64) *columnData {
+	w.buf.Write(binary.LittleEndian.AppendUint64(nil, v))
+	return w
+}
+
+func (w *columnData) bytes(b ...byte) *columnData {
+	w.buf.Write(b)
+	return w
+}
+
+func (w *columnData) strings(values ...string) *columnData {
+	for _, v := range values {
+		w.string(v)
+	}
+	return w
+}
+
+func data() *columnData {
+	return &columnData{}
+}
+
+func readColumn(typeName string, rows int, encoded []byte) ([]string, error) {
+	column, err := parseColumnType(typeName, "UTC")
+	if err != nil {
+		return nil, err
+	}
+	r := &nativeReader{r: bufio.NewReader(bytes.NewReader(encoded))}
+	if err := column.readPrefix(r); err != nil {
+		return nil, err
+	}
+	return column.read(r, rows)
+}
+
+func TestColumnDecoders(t *testing.T) {
+	tests := []struct {
+		typeName string
+		rows     int
+		data     *columnData
+		want     []string
+	}{
+		{"UInt8", 2, data().bytes(0, 255), []string{"0", "255"}},
+		{"Int16", 1, data().uint16(0xfffe), []string{"-2"}},
+		{"UInt64", 1, data().uint64(18446744073709551615), []string{"18446744073709551615"}},
+		{"Int64", 1, data().uint64(0xffffffffffffffff), []string{"-1"}},
+		{"Float64", 1, data().uint64(0x3ff8000000000000), []string{"1.5"}},
+		{"Bool", 2, data().bytes(1, 0), []string{"true", "false"}},
+		{"String", 2, data().strings("", "tab\there"), []string{"", "tab\there"}},
+		{"FixedString(4)", 2, data().bytes('a', 'b', 0, 0, 'w', 'x', 'y', 'z'), []string{"ab", "wxyz"}},
+		{"Nullable(Int32)", 3, data().bytes(0, 1, 0).uint32(5).uint32(0).uint32(0xfffffff9), []string{"5", "", "-7"}},
+		{"Nullable(String)", 2, data().bytes(1, 0).strings("", "x"), []string{"", "x"}},
+		{
+			"LowCardinality(String)", 3,
+			data().uint64(1).uint64(lowCardinalityKeysUInt8|lowCardinalityHasAdditionalKeys).
+				uint64(2).strings("a", "b").uint64(3).bytes(1, 0, 1),
+			[]string{"b", "a", "b"},
+		},
+		{
+			"LowCardinality(Nullable(String))", 4,
+			data().uint64(1).uint64(lowCardinalityKeysUInt16|lowCardinalityHasAdditionalKeys).
+				uint64(3).strings("", "a", "b").uint64(4).uint16(1).uint16(0).uint16(2).uint16(1),
+			[]string{"a", "", "b", "a"},
+		},
+		{"Array(String)", 3, data().uint64(2).uint64(2).uint64(3).strings("a", "it's", "b"), []string{`['a','it\'s']`, "[]", "['b']"}},
+		{"Array(UInt8)", 1, data().uint64(2).bytes(1, 2), []string{"[1,2]"}},
+		{"Array(Nullable(Float32))", 1, data().uint64(1).bytes(0).uint32(0x3f800000), []string{"[1]"}},
+		{"Enum8('a' = 1, 'b=c' = -2)", 2, data().bytes(1, 0xfe), []string{"a", "b=c"}},
+		{"Enum16('x' = 1000, 'y' = -1000)", 2, data().uint16(1000).uint16(0xfc18), []string{"x", "y"}},
+		{"Decimal(9, 2)", 3, data().uint32(12345).uint32(0xfffffffb).uint32(0), []string{"123.45", "-0.05", "0.00"}},
+		{"Decimal(18, 0)", 1, data().uint64(42), []string{"42"}},
+		{"Decimal32(4)", 1, data().uint32(1), []string{"0.0001"}},
+		{"Decimal64(3)", 1, data().uint64(0xfffffffffffffa24), []string{"-1.500"}},
+		{"Date", 1, data().uint16(19675), []string{"2023-11-14"}},
+		{"DateTime", 1, data().uint32(1700000000), []string{"2023-11-14 22:13:20"}},
+		{"DateTime('Asia/Tokyo')", 1, data().uint32(1700000000), []string{"2023-11-15 07:13:20"}},
+		{"DateTime64(3)", 1, data().uint64(1700000000123), []string{"2023-11-14 22:13:20.123"}},
+		{"DateTime64(6, 'UTC')", 1, data().uint64(1700000000000042), []string{"2023-11-14 22:13:20.000042"}},
+		{"DateTime64(0)", 1, data().uint64(1700000000), []string{"2023-11-14 22:13:20"}},
+		{"UUID", 1, data().uint64(0x0123456789abcdef).uint64(0xfedcba9876543210), []string{"01234567-89ab-cdef-fedc-ba9876543210"}},
+		{"IPv4", 1, data().bytes(1, 0, 0, 127), []string{"127.0.0.1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.typeName, func(t *testing.T) {
+			got, err := readColumn(tt.typeName, tt.rows, tt.data.buf.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestColumnDecoderErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		typeName string
+		rows     int
+		data     *columnData
+		wantErr  string
+	}{
+		{"unsupported type", "Map(String, UInt64)", 1, data(), "unsupported column type"},
+		{"unsupported decimal", "Decimal(38, 2)", 1, data(), "unsupported column type"},
+		{"malformed type", "Nullable(String", 1, data(), "unexpected type"},
+		{"truncated values", "UInt32", 2, data().uint32(1), "EOF"},
+		{"low cardinality version", "LowCardinality(String)", 1, data().uint64(2), "serialization version 2"},
+		{
+			"low cardinality key out of dictionary", "LowCardinality(String)", 1,
+			data().uint64(1).uint64(lowCardinalityHasAdditionalKeys).uint64(1).strings("a").uint64(1).bytes(3),
+			"out of its dictionary",
+		},
+		{
+			"low cardinality global dictionary", "LowCardinality(String)", 1,
+			data().uint64(1).uint64(lowCardinalityNeedGlobalDictionary),
+			"global dictionaries",
+		},
+		{"array offsets going back", "Array(UInt8)", 2, data().uint64(2).uint64(1).bytes(1, 2), "invalid array offset"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readColumn(tt.typeName, tt.rows, tt.data.buf.Bytes())
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSplitTypeName(t *testing.T) {
+	tests := []struct {
+		typeName string
+		name     string
+		args     []string
+	}{
+		{"String", "String", nil},
+		{"Nullable(String)", "Nullable", []string{"String"}},
+		{"Map(String, Array(Tuple(UInt8, String)))", "Map", []string{"String", "Array(Tuple(UInt8, String))"}},
+		{"Enum8('a,b' = 1, 'c\\'d' = 2)", "Enum8", []string{"'a,b' = 1", "'c\\'d' = 2"}},
+	}
+	for _, tt := range tests {
+		name, args, err := splitTypeName(tt.typeName)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.typeName, err)
+		}
+		if name != tt.name || !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("splitTypeName(%s) = %s %q, want %s %q", tt.typeName, name, args, tt.name, tt.args)
+		}
+	}
+}
@@ -0,0 +1,389 @@
+package clickhouse
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Revision of the native protocol spoken by NativeTransport. It is the first
+// revision sending settings as strings, the server adapts to older clients.
+const nativeRevision = 54429
+
+const (
+	nativeClientName   = "clickhouse-exporter"
+	nativeMajorVersion = 1
+	nativeMinorVersion = 0
+)
+
+// Packets sent by the client.
+const (
+	clientHello = 0
+	clientQuery = 1
+	clientData  = 2
+)
+
+// Packets sent by the server.
+const (
+	serverHello        = 0
+	serverData         = 1
+	serverException    = 2
+	serverProgress     = 3
+	serverPong         = 4
+	serverEndOfStream  = 5
+	serverProfileInfo  = 6
+	serverTotals       = 7
+	serverExtremes     = 8
+	serverTablesStatus = 9
+	serverLog          = 10
+	serverTableColumns = 11
+)
+
+const (
+	queryKindInitial   = 1
+	interfaceTCP       = 1
+	stageComplete      = 2
+	compressionDisable = 0
+)
+
+// Exception is an error sent by the server.
+type Exception struct {
+	Code       int32
+	Name       string
+	Message    string
+	StackTrace string
+	Nested     *Exception
+}
+
+func (e *Exception) Error() string {
+	return fmt.Sprintf("code: %d, %s: %s", e.Code, e.Name, e.Message)
+}
+
+type nativeWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *nativeWriter) uvarint(v uint64) {
+	w.buf.Write(binary.AppendUvarint(nil, v))
+}
+
+func (w *nativeWriter) string(s string) {
+	w.uvarint(uint64(len(s)))
+	w.buf.WriteString(s)
+}
+
+func (w *nativeWriter) uint8(v uint8) {
+	w.buf.WriteByte(v)
+}
+
+func (w *nativeWriter) int32(v int32) {
+	w.buf.Write(binary.LittleEndian.AppendUint32(nil, uint32(v)))
+}
+
+type nativeReader struct {
+	r *bufio.Reader
+}
+
+func (r *nativeReader) uvarint() (uint64, error) {
+	return binary.ReadUvarint(r.r)
+}
+
+func (r *nativeReader) string() (string, error) {
+	n, err := r.uvarint()
+	if err != nil {
+		return "", err
+	}
+	if n > math.MaxInt32 {
+		return "", fmt.Errorf("string of %d bytes is too long", n)
+	}
+	b, err := r.bytes(int(n))
+	return string(b), err
+}
+
+func (r *nativeReader) bytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := io.ReadFull(r.r, b)
+	return b, err
+}
+
+func (r *nativeReader) uint8() (uint8, error) {
+	return r.r.ReadByte()
+}
+
+func (r *nativeReader) bool() (bool, error) {
+	v, err := r.uint8()
+	return v != 0, err
+}
+
+func (r *nativeReader) uint32() (uint32, error) {
+	b, err := r.bytes(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+func (r *nativeReader) uint64() (uint64, error) {
+	b, err := r.bytes(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+func (r *nativeReader) int32() (int32, error) {
+	v, err := r.uint32()
+	return int32(v), err
+}
+
+// writeHello writes the handshake opening every connection.
+func writeHello(w *nativeWriter, database string, user string, password string) {
+	w.uvarint(clientHello)
+	w.string(nativeClientName)
+	w.uvarint(nativeMajorVersion)
+	w.uvarint(nativeMinorVersion)
+	w.uvarint(nativeRevision)
+	w.string(database)
+	w.string(user)
+	w.string(password)
+}
+
+type serverInfo struct {
+	name     string
+	revision uint64
+	timezone string
+}
+
+// readHello reads the answer of the server to writeHello.
+func readHello(r *nativeReader) (serverInfo, error) {
+	packet, err := r.uvarint()
+	if err != nil {
+		return serverInfo{}, err
+	}
+	switch packet {
+	case serverHello:
+	case serverException:
+		exception, err := readException(r)
+		if err != nil {
+			return serverInfo{}, err
+		}
+		return serverInfo{}, exception
+	default:
+		return serverInfo{}, fmt.Errorf("unexpected packet %d during handshake", packet)
+	}
+
+	var info serverInfo
+	if info.name, err = r.string(); err != nil {
+		return info, err
+	}
+	for range 2 { // major and minor version
+		if _, err = r.uvarint(); err != nil {
+			return info, err
+		}
+	}
+	if info.revision, err = r.uvarint(); err != nil {
+		return info, err
+	}
+	if info.timezone, err = r.string(); err != nil {
+		return info, err
+	}
+	if _, err = r.string(); err != nil { // display name
+		return info, err
+	}
+	if _, err = r.uvarint(); err != nil { // patch version
+		return info, err
+	}
+	return info, nil
+}
+
+// writeQuery writes a query packet followed by the empty data block telling the
+// server there is no external table to wait for.
+func writeQuery(w *nativeWriter, query string, settings map[string]string) {
+	w.uvarint(clientQuery)
+	w.string("") // query id, chosen by the server
+
+	// client info
+	w.uint8(queryKindInitial)
+	w.string("") // initial user
+	w.string("") // initial query id
+	w.string("0.0.0.0:0")
+	w.uint8(interfaceTCP)
+	w.string("") // os user
+	w.string("") // client hostname
+	w.string(nativeClientName)
+	w.uvarint(nativeMajorVersion)
+	w.uvarint(nativeMinorVersion)
+	w.uvarint(nativeRevision)
+	w.string("") // quota key
+	w.uvarint(0) // patch version
+
+	for name, value := range settings {
+		w.string(name)
+		w.uvarint(0) // flags
+		w.string(value)
+	}
+	w.string("") // end of settings
+
+	w.uvarint(stageComplete)
+	w.uvarint(compressionDisable)
+	w.string(query)
+
+	writeEmptyBlock(w)
+}
+
+func writeEmptyBlock(w *nativeWriter) {
+	w.uvarint(clientData)
+	w.string("") // temporary table name
+
+	// block info
+	w.uvarint(1)
+	w.uint8(0) // is overflows
+	w.uvarint(2)
+	w.int32(-1) // bucket num
+	w.uvarint(0)
+
+	w.uvarint(0) // columns
+	w.uvarint(0) // rows
+}
+
+func readException(r *nativeReader) (*Exception, error) {
+	var e Exception
+	var err error
+	if e.Code, err = r.int32(); err != nil {
+		return nil, err
+	}
+	if e.Name, err = r.string(); err != nil {
+		return nil, err
+	}
+	if e.Message, err = r.string(); err != nil {
+		return nil, err
+	}
+	if e.StackTrace, err = r.string(); err != nil {
+		return nil, err
+	}
+	hasNested, err := r.bool()
+	if err != nil {
+		return nil, err
+	}
+	if hasNested {
+		if e.Nested, err = readException(r); err != nil {
+			return nil, err
+		}
+	}
+	return &e, nil
+}
+
+type nativeBlock struct {
+	columns []Column
+	rows    [][]string
+}
+
+// readBlock reads a data block and turns its columns into rows of text values,
+// the same values the TabSeparated format would hold.
+func readBlock(r *nativeReader, timezone string) (nativeBlock, error) {
+	var block nativeBlock
+	if _, err := r.string(); err != nil { // temporary table name
+		return block, err
+	}
+
+	for {
+		field, err := r.uvarint()
+		if err != nil {
+			return block, err
+		}
+		switch field {
+		case 0:
+		case 1: // is overflows
+			_, err = r.bool()
+		case 2: // bucket num
+			_, err = r.int32()
+		default:
+			err = fmt.Errorf("unknown block info field %d", field)
+		}
+		if err != nil {
+			return block, err
+		}
+		if field == 0 {
+			break
+		}
+	}
+
+	numColumns, err := r.uvarint()
+	if err != nil {
+		return block, err
+	}
+	numRows, err := r.uvarint()
+	if err != nil {
+		return block, err
+	}
+	if numRows > math.MaxInt32 {
+		return block, fmt.Errorf("block of %d rows is too large", numRows)
+	}
+
+	values := make([][]string, numColumns)
+	for i := range values {
+		var column Column
+		if column.Name, err = r.string(); err != nil {
+			return block, err
+		}
+		if column.Type, err = r.string(); err != nil {
+			return block, err
+		}
+		block.columns = append(block.columns, column)
+
+		if numRows == 0 {
+			continue
+		}
+		columnType, err := parseColumnType(column.Type, timezone)
+		if err != nil {
+			return block, fmt.Errorf("column %s: %w", column.Name, err)
+		}
+		if err := columnType.readPrefix(r); err != nil {
+			return block, fmt.Errorf("column %s: %w", column.Name, err)
+		}
+		if values[i], err = columnType.read(r, int(numRows)); err != nil {
+			return block, fmt.Errorf("column %s: %w", column.Name, err)
+		}
+	}
+
+	block.rows = make([][]string, numRows)
+	for row := range block.rows {
+		block.rows[row] = make([]string, numColumns)
+		for column := range values {
+			block.rows[row][column] = values[column][row]
+		}
+	}
+	return block, nil
+}
+
+// skipProgress reads a progress packet, the exporter has no use for it.
+func skipProgress(r *nativeReader) error {
+	// read rows, read bytes, total rows to read, written rows, written bytes
+	for range 5 {
+		if _, err := r.uvarint(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipProfileInfo reads a profile info packet, the exporter has no use for it.
+func skipProfileInfo(r *nativeReader) error {
+	// rows, blocks, bytes
+	for range 3 {
+		if _, err := r.uvarint(); err != nil {
+			return err
+		}
+	}
+	if _, err := r.bool(); err != nil { // applied limit
+		return err
+	}
+	if _, err := r.uvarint(); err != nil { // rows before limit
+		return err
+	}
+	_, err := r.bool() // calculated rows before limit
+	return err
+}
@@ -0,0 +1,190 @@
+package clickhouse
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	nativeDefaultPort       = "9000"
+	nativeDefaultSecurePort = "9440"
+	nativeDefaultDatabase   = "default"
+	nativeDefaultUser       = "default"
+)
+
+// url parameters of the query uri which are not clickhouse settings
+var nativeReservedParameters = map[string]bool{
+	"query":          true,
+	"database":       true,
+	"default_format": true,
+	"user":           true,
+	"password":       true,
+}
+
+// NativeTransport talks the native TCP protocol of clickhouse, port 9000 or
+// 9440 with TLS. Each query opens its own connection.
+type NativeTransport struct {
+	// TLSConfig enables TLS when set.
+	TLSConfig *tls.Config
+	// Timeout bounds a whole query, on top of the deadline of its context.
+	Timeout time.Duration
+}
+
+// Query runs the query found in the `query` parameter of the uri. The other
+// parameters are sent as settings, except `database` which selects the
+// database, like the HTTP interface does.
+func (t *NativeTransport) Query(ctx context.Context, query string, user string, password string) (*Result, error) {
+	uri, err := url.Parse(query)
+	if err != nil {
+		return nil, err
+	}
+	url_values := uri.Query()
+
+	database := url_values.Get("database")
+	if database == "" {
+		database = strings.Trim(uri.Path, "/")
+	}
+	if database == "" {
+		database = nativeDefaultDatabase
+	}
+	if user == "" {
+		user = nativeDefaultUser
+	}
+
+	settings := make(map[string]string)
+	for name := range url_values {
+		if !nativeReservedParameters[name] {
+			settings[name] = url_values.Get(name)
+		}
+	}
+
+	if t.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.Timeout)
+		defer cancel()
+	}
+
+	conn, err := t.dial(ctx, uri)
+	if err != nil {
+		return nil, fmt.Errorf("error scraping clickhouse: %v", err)
+	}
+	defer conn.Close()
+
+	// closing the connection unblocks any pending read once ctx is done
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := runNativeQuery(conn, database, user, password, url_values.Get("query"), settings)
+	if err != nil && ctx.Err() != nil {
+		return nil, fmt.Errorf("error scraping clickhouse: %w", ctx.Err())
+	}
+	// the deadline of the connection may pass just before ctx is done
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return nil, fmt.Errorf("error scraping clickhouse: %w", context.DeadlineExceeded)
+	}
+	return result, err
+}
+
+func (t *NativeTransport) dial(ctx context.Context, uri *url.URL) (net.Conn, error) {
+	address := uri.Host
+	if uri.Port() == "" {
+		port := nativeDefaultPort
+		if t.TLSConfig != nil {
+			port = nativeDefaultSecurePort
+		}
+		address = net.JoinHostPort(uri.Hostname(), port)
+	}
+
+	if t.TLSConfig != nil {
+		dialer := tls.Dialer{Config: t.TLSConfig}
+		return dialer.DialContext(ctx, "tcp", address)
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", address)
+}
+
+func runNativeQuery(conn net.Conn, database string, user string, password string, query string, settings map[string]string) (*Result, error) {
+	r := &nativeReader{r: bufio.NewReader(conn)}
+
+	var w nativeWriter
+	writeHello(&w, database, user, password)
+	if _, err := conn.Write(w.buf.Bytes()); err != nil {
+		return nil, err
+	}
+	info, err := readHello(r)
+	if err != nil {
+		return nil, fmt.Errorf("handshake: %w", err)
+	}
+
+	w.buf.Reset()
+	writeQuery(&w, query, settings)
+	if _, err := conn.Write(w.buf.Bytes()); err != nil {
+		return nil, err
+	}
+
+	var result Result
+	for {
+		packet, err := r.uvarint()
+		if err != nil {
+			return nil, err
+		}
+
+		switch packet {
+		case serverData:
+			block, err := readBlock(r, info.timezone)
+			if err != nil {
+				return nil, err
+			}
+			// the first block only carries the header of the result
+			if result.Columns == nil {
+				result.Columns = block.columns
+			}
+			result.Rows = append(result.Rows, block.rows...)
+		case serverTotals, serverExtremes, serverLog:
+			if _, err := readBlock(r, info.timezone); err != nil {
+				return nil, err
+			}
+		case serverException:
+			exception, err := readException(r)
+			if err != nil {
+				return nil, err
+			}
+			return nil, exception
+		case serverProgress:
+			if err := skipProgress(r); err != nil {
+				return nil, err
+			}
+		case serverProfileInfo:
+			if err := skipProfileInfo(r); err != nil {
+				return nil, err
+			}
+		case serverTableColumns:
+			// external table name and columns description
+			for range 2 {
+				if _, err := r.string(); err != nil {
+					return nil, err
+				}
+			}
+		case serverEndOfStream:
+			if result.Columns == nil {
+				result.Columns = []Column{}
+			}
+			return &result, nil
+		default:
+			return nil, fmt.Errorf("unexpected packet %d from %s", packet, info.name)
+		}
+	}
+}
@@ -0,0 +1,487 @@
+package clickhouse
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clientHandshake is what the stub server read from the client.
+type clientHandshake struct {
+	clientName string
+	revision   uint64
+	database   string
+	user       string
+	password   string
+	query      string
+	settings   map[string]string
+}
+
+// readClientHello reads the hello packet written by writeHello.
+func readClientHello(r *nativeReader, h *clientHandshake) error {
+	packet, err := r.uvarint()
+	if err != nil {
+		return err
+	}
+	if packet != clientHello {
+		return fmt.Errorf("got packet %d, want hello", packet)
+	}
+	if h.clientName, err = r.string(); err != nil {
+		return err
+	}
+	for range 2 { // major and minor version
+		if _, err = r.uvarint(); err != nil {
+			return err
+		}
+	}
+	if h.revision, err = r.uvarint(); err != nil {
+		return err
+	}
+	if h.database, err = r.string(); err != nil {
+		return err
+	}
+	if h.user, err = r.string(); err != nil {
+		return err
+	}
+	h.password, err = r.string()
+	return err
+}
+
+// readClientQuery reads the query packet and the empty block written by
+// writeQuery.
+func readClientQuery(r *nativeReader, h *clientHandshake) error {
+	packet, err := r.uvarint()
+	if err != nil {
+		return err
+	}
+	if packet != clientQuery {
+		return fmt.Errorf("got packet %d, want query", packet)
+	}
+	if _, err = r.string(); err != nil { // query id
+		return err
+	}
+
+	// client info
+	fields := []func() error{
+		func() error { _, err := r.uint8(); return err },
+		func() error { _, err := r.string(); return err },
+		func() error { _, err := r.string(); return err },
+		func() error { _, err := r.string(); return err },
+		func() error { _, err := r.uint8(); return err },
+		func() error { _, err := r.string(); return err },
+		func() error { _, err := r.string(); return err },
+		func() error { _, err := r.string(); return err },
+		func() error { _, err := r.uvarint(); return err },
+		func() error { _, err := r.uvarint(); return err },
+		func() error { _, err := r.uvarint(); return err },
+		func() error { _, err := r.string(); return err },
+		func() error { _, err := r.uvarint(); return err },
+	}
+	for _, field := range fields {
+		if err := field(); err != nil {
+			return err
+		}
+	}
+
+	h.settings = make(map[string]string)
+	for {
+		name, err := r.string()
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			break
+		}
+		if _, err := r.uvarint(); err != nil { // flags
+			return err
+		}
+		if h.settings[name], err = r.string(); err != nil {
+			return err
+		}
+	}
+
+	for range 2 { // stage and compression
+		if _, err = r.uvarint(); err != nil {
+			return err
+		}
+	}
+	if h.query, err = r.string(); err != nil {
+		return err
+	}
+
+	if packet, err = r.uvarint(); err != nil {
+		return err
+	}
+	if packet != clientData {
+		return fmt.Errorf("got packet %d, want empty data block", packet)
+	}
+	block, err := readBlock(r, "UTC")
+	if err != nil {
+		return err
+	}
+	if len(block.columns) != 0 || len(block.rows) != 0 {
+		return fmt.Errorf("got block of %d columns and %d rows, want an empty one", len(block.columns), len(block.rows))
+	}
+	return nil
+}
+
+func writeServerHello(w *columnData, timezone string) {
+	w.uvarint(serverHello)
+	w.string("ClickHouse")
+	w.uvarint(24)
+	w.uvarint(8)
+	w.uvarint(nativeRevision)
+	w.string(timezone)
+	w.string("stub")
+	w.uvarint(1)
+}
+
+type stubColumn struct {
+	name     string
+	typeName string
+	data     *columnData
+}
+
+func writeServerBlock(w *columnData, packet uint64, rows int, columns ...stubColumn) {
+	w.uvarint(packet)
+	w.string("")
+	w.uvarint(1)
+	w.uint8(0)
+	w.uvarint(2)
+	w.int32(-1)
+	w.uvarint(0)
+	w.uvarint(uint64(len(columns)))
+	w.uvarint(uint64(rows))
+	for _, column := range columns {
+		w.string(column.name)
+		w.string(column.typeName)
+		if rows > 0 {
+			w.bytes(column.data.buf.Bytes()...)
+		}
+	}
+}
+
+func writeServerProgress(w *columnData) {
+	w.uvarint(serverProgress)
+	for _, v := range []uint64{2, 64, 2, 0, 0} {
+		w.uvarint(v)
+	}
+}
+
+func writeServerProfileInfo(w *columnData) {
+	w.uvarint(serverProfileInfo)
+	w.uvarint(2)
+	w.uvarint(1)
+	w.uvarint(64)
+	w.uint8(0)
+	w.uvarint(0)
+	w.uint8(0)
+}
+
+// stubServer accepts a single connection, records the handshake of the client
+// then writes the answer returned by respond and hangs up, or waits for the
+// client to hang up when keepOpen is set.
+func stubServer(t *testing.T, keepOpen bool, respond func(h *clientHandshake) []byte) (string, <-chan clientHandshake) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handshakes := make(chan clientHandshake, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := &nativeReader{r: bufio.NewReader(conn)}
+
+		var h clientHandshake
+		if err := readClientHello(r, &h); err != nil {
+			t.Errorf("client hello: %v", err)
+			return
+		}
+		answer := respond(&h)
+		if h.query == "" {
+			// the answer ends the handshake, no query follows
+			handshakes <- h
+			conn.Write(answer)
+			return
+		}
+
+		var hello columnData
+		writeServerHello(&hello, "UTC")
+		conn.Write(hello.buf.Bytes())
+		if err := readClientQuery(r, &h); err != nil {
+			t.Errorf("client query: %v", err)
+			return
+		}
+		handshakes <- h
+		conn.Write(answer)
+		if keepOpen {
+			r.r.ReadByte()
+		}
+	}()
+	t.Cleanup(func() {
+		listener.Close()
+		<-done
+	})
+	return listener.Addr().String(), handshakes
+}
+
+// expectQuery makes the stub server read a query before answering.
+func expectQuery(answer func(w *columnData)) func(h *clientHandshake) []byte {
+	return func(h *clientHandshake) []byte {
+		h.query = "pending"
+		var w columnData
+		answer(&w)
+		return w.buf.Bytes()
+	}
+}
+
+func nativeQueryURI(address string, path string, values url.Values) string {
+	uri := url.URL{Scheme: "clickhouse", Host: address, Path: path, RawQuery: values.Encode()}
+	return uri.String()
+}
+
+func TestNativeTransportQuery(t *testing.T) {
+	address, handshakes := stubServer(t, false, expectQuery(func(w *columnData) {
+		columns := []stubColumn{
+			{name: "name", typeName: "LowCardinality(String)"},
+			{name: "value", typeName: "Nullable(UInt64)"},
+		}
+		// header block, then a block per chunk of rows
+		writeServerBlock(w, serverData, 0, columns...)
+		writeServerProgress(w)
+		columns[0].data = data().uint64(1).uint64(lowCardinalityHasAdditionalKeys).uint64(2).strings("a", "b").uint64(2).bytes(0, 1)
+		columns[1].data = data().bytes(0, 1).uint64(7).uint64(0)
+		writeServerBlock(w, serverData, 2, columns...)
+		columns[0].data = data().uint64(1).uint64(lowCardinalityHasAdditionalKeys).uint64(1).strings("c").uint64(1).bytes(0)
+		columns[1].data = data().bytes(0).uint64(9)
+		writeServerBlock(w, serverData, 1, columns...)
+		writeServerProfileInfo(w)
+		writeServerBlock(w, serverTotals, 1, stubColumn{"value", "UInt64", data().uint64(16)})
+		writeServerProgress(w)
+		w.uvarint(serverEndOfStream)
+	}))
+
+	transport := NativeTransport{Timeout: 5 * time.Second}
+	query := "SELECT name, value FROM system.events"
+	uri := nativeQueryURI(address, "/", url.Values{
+		"query":              {query},
+		"database":           {"system"},
+		"max_execution_time": {"10"},
+	})
+	result, err := transport.Query(context.Background(), uri, "exporter", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := <-handshakes
+	want := clientHandshake{
+		clientName: nativeClientName,
+		revision:   nativeRevision,
+		database:   "system",
+		user:       "exporter",
+		password:   "secret",
+		query:      query,
+		settings:   map[string]string{"max_execution_time": "10"},
+	}
+	if !reflect.DeepEqual(h, want) {
+		t.Errorf("handshake = %+v, want %+v", h, want)
+	}
+
+	wantColumns := []Column{{"name", "LowCardinality(String)"}, {"value", "Nullable(UInt64)"}}
+	if !reflect.DeepEqual(result.Columns, wantColumns) {
+		t.Errorf("columns = %v, want %v", result.Columns, wantColumns)
+	}
+	wantRows := [][]string{{"a", "7"}, {"b", ""}, {"c", "9"}}
+	if !reflect.DeepEqual(result.Rows, wantRows) {
+		t.Errorf("rows = %q, want %q", result.Rows, wantRows)
+	}
+}
+
+func TestNativeTransportDefaults(t *testing.T) {
+	address, handshakes := stubServer(t, false, expectQuery(func(w *columnData) {
+		w.uvarint(serverEndOfStream)
+	}))
+
+	transport := NativeTransport{}
+	uri := nativeQueryURI(address, "/metrics_db", url.Values{"query": {"SELECT 1"}})
+	result, err := transport.Query(context.Background(), uri, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Columns == nil || len(result.Rows) != 0 {
+		t.Errorf("got %+v, want an empty result", result)
+	}
+
+	h := <-handshakes
+	if h.database != "metrics_db" || h.user != nativeDefaultUser || h.password != "" {
+		t.Errorf("got database %q user %q password %q", h.database, h.user, h.password)
+	}
+	if len(h.settings) != 0 {
+		t.Errorf("got settings %v, want none", h.settings)
+	}
+}
+
+func TestNativeTransportException(t *testing.T) {
+	address, _ := stubServer(t, false, expectQuery(func(w *columnData) {
+		writeServerBlock(w, serverData, 0, stubColumn{name: "value", typeName: "UInt64"})
+		writeServerProgress(w)
+		w.uvarint(serverException)
+		w.int32(60)
+		w.string("DB::Exception")
+		w.string("Table system.kafka_consumers does not exist")
+		w.string("stack")
+		w.uint8(1)
+		w.int32(60)
+		w.string("DB::Exception")
+		w.string("nested")
+		w.string("")
+		w.uint8(0)
+		// never reached, the exception ends the query
+		w.uvarint(serverEndOfStream)
+	}))
+
+	transport := NativeTransport{}
+	uri := nativeQueryURI(address, "", url.Values{"query": {"SELECT value FROM system.kafka_consumers"}})
+	_, err := transport.Query(context.Background(), uri, "", "")
+
+	var exception *Exception
+	if !errors.As(err, &exception) {
+		t.Fatalf("got error %v, want an exception", err)
+	}
+	if exception.Code != 60 || exception.Message != "Table system.kafka_consumers does not exist" {
+		t.Errorf("got exception %+v", exception)
+	}
+	if exception.Nested == nil || exception.Nested.Message != "nested" {
+		t.Errorf("got nested exception %+v", exception.Nested)
+	}
+	if want := "code: 60, DB::Exception: Table system.kafka_consumers does not exist"; err.Error() != want {
+		t.Errorf("got error %q, want %q", err, want)
+	}
+}
+
+func TestNativeTransportHandshakeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		answer  func(w *columnData)
+		wantErr string
+	}{
+		{
+			name: "authentication failure",
+			answer: func(w *columnData) {
+				w.uvarint(serverException)
+				w.int32(516)
+				w.string("DB::Exception")
+				w.string("exporter: Authentication failed")
+				w.string("")
+				w.uint8(0)
+			},
+			wantErr: "handshake: code: 516, DB::Exception: exporter: Authentication failed",
+		},
+		{
+			name:    "unexpected packet",
+			answer:  func(w *columnData) { w.uvarint(serverPong) },
+			wantErr: "handshake: unexpected packet 4 during handshake",
+		},
+		{
+			name: "truncated hello",
+			answer: func(w *columnData) {
+				w.uvarint(serverHello)
+				w.string("ClickHouse")
+			},
+			wantErr: "handshake: EOF",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address, _ := stubServer(t, false, func(h *clientHandshake) []byte {
+				var w columnData
+				tt.answer(&w)
+				return w.buf.Bytes()
+			})
+			transport := NativeTransport{Timeout: 5 * time.Second}
+			uri := nativeQueryURI(address, "", url.Values{"query": {"SELECT 1"}})
+			_, err := transport.Query(context.Background(), uri, "exporter", "wrong")
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNativeTransportStreamErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		answer  func(w *columnData)
+		wantErr string
+	}{
+		{
+			name:    "unexpected packet",
+			answer:  func(w *columnData) { w.uvarint(serverPong) },
+			wantErr: "unexpected packet 4 from ClickHouse",
+		},
+		{
+			name: "unsupported column type",
+			answer: func(w *columnData) {
+				writeServerBlock(w, serverData, 1, stubColumn{"m", "Map(String, UInt64)", data()})
+			},
+			wantErr: "column m: unsupported column type Map(String, UInt64)",
+		},
+		{
+			name: "connection closed before end of stream",
+			answer: func(w *columnData) {
+				writeServerBlock(w, serverData, 0, stubColumn{name: "value", typeName: "UInt64"})
+				writeServerProgress(w)
+			},
+			wantErr: "EOF",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address, _ := stubServer(t, false, func(h *clientHandshake) []byte {
+				h.query = "pending"
+				var w columnData
+				tt.answer(&w)
+				return w.buf.Bytes()
+			})
+			transport := NativeTransport{Timeout: 5 * time.Second}
+			uri := nativeQueryURI(address, "", url.Values{"query": {"SELECT 1"}})
+			_, err := transport.Query(context.Background(), uri, "", "")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNativeTransportTimeout(t *testing.T) {
+	address, _ := stubServer(t, true, expectQuery(func(w *columnData) {
+		// a header and no end of stream, the query never finishes
+		writeServerBlock(w, serverData, 0, stubColumn{name: "value", typeName: "UInt64"})
+	}))
+
+	transport := NativeTransport{Timeout: 100 * time.Millisecond}
+	uri := nativeQueryURI(address, "", url.Values{"query": {"SELECT sleep(3)"}})
+	start := time.Now()
+	_, err := transport.Query(context.Background(), uri, "", "")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want the deadline to be exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("query returned after %s", elapsed)
+	}
+}
@@ -0,0 +1,205 @@
+package clickhouse
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ResultFormat is the output format requested from the HTTP interface.
+	ResultFormat = "TabSeparatedWithNamesAndTypes"
+
+	tabSeparatedNull = `\N`
+)
+
+type Column struct {
+	Name string
+	Type string
+}
+
+// Result holds the unescaped cells of a query result. NULL cells are empty.
+type Result struct {
+	Columns []Column
+	Rows    [][]string
+}
+
+// ParseTabSeparatedWithNamesAndTypes parses a TabSeparatedWithNamesAndTypes
+// response: a line of column names, a line of column types and the rows.
+func ParseTabSeparatedWithNamesAndTypes(data []byte) (*Result, error) {
+	lines := bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n"))
+	if len(lines) < 2 {
+		return nil, fmt.Errorf("parseTabSeparated: expected names and types header, got %d lines", len(lines))
+	}
+
+	names := splitTabSeparatedLine(lines[0])
+	types := splitTabSeparatedLine(lines[1])
+	if len(names) != len(types) {
+		return nil, fmt.Errorf("parseTabSeparated: %d column names but %d column types", len(names), len(types))
+	}
+
+	result := &Result{Columns: make([]Column, len(names))}
+	for i := range names {
+		result.Columns[i] = Column{Name: names[i], Type: types[i]}
+	}
+
+	for i, line := range lines[2:] {
+		row := splitTabSeparatedLine(line)
+		if len(row) != len(names) {
+			return nil, fmt.Errorf("parseTabSeparated: unexpected %d line: %s", i, line)
+		}
+		result.Rows = append(result.Rows, row)
+	}
+
+	return result, nil
+}
+
+func splitTabSeparatedLine(line []byte) []string {
+	fields := bytes.Split(line, []byte("\t"))
+	values := make([]string, len(fields))
+	for i, field := range fields {
+		values[i] = unescapeTabSeparated(string(field))
+	}
+	return values
+}
+
+// unescapeTabSeparated reverts the escaping clickhouse applies to the values of
+// the TabSeparated formats.
+func unescapeTabSeparated(field string) string {
+	if field == tabSeparatedNull {
+		return ""
+	}
+	if !strings.Contains(field, `\`) {
+		return field
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(field); i++ {
+		if field[i] != '\\' || i+1 == len(field) {
+			out.WriteByte(field[i])
+			continue
+		}
+		i++
+		switch field[i] {
+		case 'b':
+			out.WriteByte('\b')
+		case 'f':
+			out.WriteByte('\f')
+		case 'r':
+			out.WriteByte('\r')
+		case 'n':
+			out.WriteByte('\n')
+		case 't':
+			out.WriteByte('\t')
+		case '0':
+			out.WriteByte(0)
+		default:
+			out.WriteByte(field[i])
+		}
+	}
+	return out.String()
+}
+
+// ColumnIndex returns the position of the named column, or -1 if the result has
+// no such column.
+func (r *Result) ColumnIndex(name string) int {
+	for i, column := range r.Columns {
+		if column.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Decode stores the rows of the result into dest, which must be a pointer to a
+// slice of structs. Struct fields are matched to columns by their `ch` tag and
+// fields without a tag are left alone, as are fields tagged `ch:"name,optional"`
+// when the result has no such column. NULL cells decode to the zero value.
+func (r *Result) Decode(dest interface{}) error {
+	slice := reflect.ValueOf(dest)
+	if slice.Kind() != reflect.Pointer || slice.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("decode: expected a pointer to a slice, got %T", dest)
+	}
+	slice = slice.Elem()
+
+	rowType := slice.Type().Elem()
+	if rowType.Kind() != reflect.Struct {
+		return fmt.Errorf("decode: expected a slice of structs, got %T", dest)
+	}
+
+	type fieldColumn struct {
+		index  []int
+		column int
+	}
+	var fields []fieldColumn
+	for _, field := range reflect.VisibleFields(rowType) {
+		tag, ok := field.Tag.Lookup("ch")
+		if !ok || tag == "-" {
+			continue
+		}
+		name, option, _ := strings.Cut(tag, ",")
+		column := r.ColumnIndex(name)
+		if column < 0 && option == "optional" {
+			continue
+		}
+		if column < 0 {
+			return fmt.Errorf("decode: result has no column %s", name)
+		}
+		fields = append(fields, fieldColumn{index: field.Index, column: column})
+	}
+
+	rows := reflect.MakeSlice(slice.Type(), 0, len(r.Rows))
+	for i, row := range r.Rows {
+		item := reflect.New(rowType).Elem()
+		for _, f := range fields {
+			if err := setValue(item.FieldByIndex(f.index), row[f.column]); err != nil {
+				return fmt.Errorf("decode: row %d column %s: %w", i, r.Columns[f.column].Name, err)
+			}
+		}
+		rows = reflect.Append(rows, item)
+	}
+	slice.Set(rows)
+
+	return nil
+}
+
+func setValue(field reflect.Value, value string) error {
+	if value == "" && field.Kind() != reflect.String {
+		field.SetZero()
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(v)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(v)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(v)
+	case reflect.Float32, reflect.Float64:
+		v, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(v)
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
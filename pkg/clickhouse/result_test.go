@@ -0,0 +1,193 @@
+package clickhouse
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTabSeparatedWithNamesAndTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		columns []Column
+		rows    [][]string
+		wantErr string
+	}{
+		{
+			name:    "names with spaces and escapes",
+			data:    "disk name\tvalue\nString\tUInt64\nmy disk\\ttab\\nline\\\\slash\t1\n",
+			columns: []Column{{"disk name", "String"}, {"value", "UInt64"}},
+			rows:    [][]string{{"my disk\ttab\nline\\slash", "1"}},
+		},
+		{
+			name:    "null cells are empty",
+			data:    "name\tvalue\nString\tNullable(UInt64)\na\t\\N\n",
+			columns: []Column{{"name", "String"}, {"value", "Nullable(UInt64)"}},
+			rows:    [][]string{{"a", ""}},
+		},
+		{
+			name:    "header only",
+			data:    "name\tvalue\nString\tUInt64\n",
+			columns: []Column{{"name", "String"}, {"value", "UInt64"}},
+		},
+		{
+			name:    "missing types line",
+			data:    "name\tvalue\n",
+			wantErr: "expected names and types header",
+		},
+		{
+			name:    "names and types mismatch",
+			data:    "name\tvalue\nString\n",
+			wantErr: "2 column names but 1 column types",
+		},
+		{
+			name:    "row with too few cells",
+			data:    "name\tvalue\nString\tUInt64\na\t1\nb\n",
+			wantErr: "unexpected 1 line",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ParseTabSeparatedWithNamesAndTypes([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result.Columns, tt.columns) {
+				t.Errorf("columns = %v, want %v", result.Columns, tt.columns)
+			}
+			if !reflect.DeepEqual(result.Rows, tt.rows) {
+				t.Errorf("rows = %q, want %q", result.Rows, tt.rows)
+			}
+		})
+	}
+}
+
+func TestUnescapeTabSeparated(t *testing.T) {
+	tests := map[string]string{
+		`plain`:       "plain",
+		`\N`:          "",
+		`a\tb`:        "a\tb",
+		`a\nb`:        "a\nb",
+		`a\\b`:        `a\b`,
+		`a\'b`:        "a'b",
+		`\b\f\r\0`:    "\b\f\r\x00",
+		`trailing\`:   `trailing\`,
+		`\\N`:         `\N`,
+		`x\\\tend\\n`: "x\\\tend\\n",
+	}
+	for field, want := range tests {
+		if got := unescapeTabSeparated(field); got != want {
+			t.Errorf("unescapeTabSeparated(%q) = %q, want %q", field, got, want)
+		}
+	}
+}
+
+type decodeNode struct {
+	Hostname string `ch:"hostname,optional"`
+}
+
+type decodeRow struct {
+	Name    string  `ch:"name"`
+	Count   int     `ch:"count"`
+	Bytes   uint64  `ch:"bytes"`
+	Ratio   float64 `ch:"ratio"`
+	Enabled bool    `ch:"enabled"`
+	Ignored string
+	decodeNode
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		result  Result
+		want    []decodeRow
+		wantErr string
+	}{
+		{
+			name: "every kind",
+			result: Result{
+				Columns: []Column{{Name: "name"}, {Name: "count"}, {Name: "bytes"}, {Name: "ratio"}, {Name: "enabled"}, {Name: "hostname"}},
+				Rows:    [][]string{{"a", "-3", "18446744073709551615", "0.5", "true", "ch-1"}},
+			},
+			want: []decodeRow{{Name: "a", Count: -3, Bytes: 18446744073709551615, Ratio: 0.5, Enabled: true, decodeNode: decodeNode{Hostname: "ch-1"}}},
+		},
+		{
+			name: "null cells decode to zero values",
+			result: Result{
+				Columns: []Column{{Name: "name"}, {Name: "count"}, {Name: "bytes"}, {Name: "ratio"}, {Name: "enabled"}},
+				Rows:    [][]string{{"", "", "", "", ""}},
+			},
+			want: []decodeRow{{}},
+		},
+		{
+			name: "optional column missing",
+			result: Result{
+				Columns: []Column{{Name: "enabled"}, {Name: "ratio"}, {Name: "bytes"}, {Name: "count"}, {Name: "name"}},
+				Rows:    [][]string{{"1", "2", "3", "4", "b"}},
+			},
+			want: []decodeRow{{Name: "b", Count: 4, Bytes: 3, Ratio: 2, Enabled: true}},
+		},
+		{
+			name: "no rows",
+			result: Result{
+				Columns: []Column{{Name: "name"}, {Name: "count"}, {Name: "bytes"}, {Name: "ratio"}, {Name: "enabled"}},
+			},
+			want: []decodeRow{},
+		},
+		{
+			name: "required column missing",
+			result: Result{
+				Columns: []Column{{Name: "name"}, {Name: "count"}, {Name: "bytes"}, {Name: "ratio"}},
+				Rows:    [][]string{{"a", "1", "2", "3"}},
+			},
+			wantErr: "result has no column enabled",
+		},
+		{
+			name: "unparsable value",
+			result: Result{
+				Columns: []Column{{Name: "name"}, {Name: "count"}, {Name: "bytes"}, {Name: "ratio"}, {Name: "enabled"}},
+				Rows:    [][]string{{"a", "many", "2", "3", "0"}},
+			},
+			wantErr: "row 0 column count",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rows []decodeRow
+			err := tt.result.Decode(&rows)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(rows, tt.want) {
+				t.Errorf("rows = %+v, want %+v", rows, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeRejectsNonSlice(t *testing.T) {
+	result := Result{}
+	var row decodeRow
+	if err := result.Decode(&row); err == nil {
+		t.Error("decoding into a struct pointer succeeded")
+	}
+	var names []string
+	if err := result.Decode(&names); err == nil {
+		t.Error("decoding into a slice of strings succeeded")
+	}
+}
@@ -3,33 +3,52 @@ package configs
 import (
 	"flag"
 	"os"
+	"time"
 )
 
 type Configuration struct {
 	ListeningAddress *string
 	MetricsEndpoint  *string
+	TimeoutOffset    *time.Duration
 	ClickhouseOnly   *bool
 	Insecure         *bool
 
+	CollectorConcurrency *int
+	CollectorTimeout     *time.Duration
+	CollectorInterval    *time.Duration
+
+	MaxExecutionTime *bool
+
 	ClickhouseScrapeURI string
 	User                string
 	Password            string
+	Cluster             string
 
 	QueryFiltersPath string
+	ProbeModulesPath string
 }
 
 func LoadConfigs() Configuration {
 	configs := Configuration{
 		ListeningAddress: flag.String("telemetry.address", ":9116", "Address on which to expose metrics."),
 		MetricsEndpoint:  flag.String("telemetry.endpoint", "/metrics", "Path under which to expose metrics."),
+		TimeoutOffset:    flag.Duration("telemetry.timeout_offset", 500*time.Millisecond, "Subtracted from the Prometheus scrape timeout to get the deadline of a scrape."),
 		ClickhouseOnly:   flag.Bool("clickhouse_only", false, "Expose only Clickhouse metrics, not metrics from the exporter itself"),
 		Insecure:         flag.Bool("insecure", true, "Ignore server certificate if using https"),
 
+		CollectorConcurrency: flag.Int("collector.concurrency", 4, "Maximum number of collectors scraping clickhouse at the same time"),
+		CollectorTimeout:     flag.Duration("collector.timeout", 10*time.Second, "Default deadline of a single collector, can be overridden per collector in the query filters file"),
+		CollectorInterval:    flag.Duration("collector.interval", 0, "Scrape clickhouse in the background on this interval and serve the latest snapshot, 0 scrapes on every request"),
+
+		MaxExecutionTime: flag.Bool("clickhouse.max_execution_time", true, "Pass the time left before the scrape deadline to clickhouse as max_execution_time, disable it for readonly=1 users"),
+
 		ClickhouseScrapeURI: getEnv("CLICKHOUSE_URI", "http://127.0.0.1:8123"),
 		User:                getEnv("CLICKHOUSE_USER", "user"),
 		Password:            getEnv("CLICKHOUSE_PASSWORD", "pass"),
+		Cluster:             getEnv("CLICKHOUSE_CLUSTER", ""),
 
 		QueryFiltersPath: getEnv("QUERY_FILTERS_PATH", "./conf/query-filters.yaml"),
+		ProbeModulesPath: getEnv("PROBE_MODULES_PATH", "./conf/probe-modules.yaml"),
 	}
 
 	// must be called after all flags are defined and before flags are accessed by the program
@@ -0,0 +1,95 @@
+package keeper
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// Four letter word commands answered on the client port of ClickHouse Keeper
+// and ZooKeeper. Each command gets its own connection, the server closes it
+// once the answer is sent.
+const (
+	CommandMonitor = "mntr"
+	CommandServer  = "srvr"
+	CommandHealth  = "ruok"
+
+	// answer to CommandHealth of a server able to serve requests
+	HealthyAnswer = "imok"
+)
+
+// maximum size of an answer, mntr is a few kilobytes at most
+const maxAnswerSize = 1 << 20
+
+// SendCommand sends a four letter word command to the keeper at address and
+// returns its answer.
+func SendCommand(ctx context.Context, address string, command string) (string, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	// closing the connection unblocks any pending read once ctx is done
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return "", err
+		}
+	}
+
+	if _, err := conn.Write([]byte(command)); err != nil {
+		return "", err
+	}
+	answer, err := io.ReadAll(io.LimitReader(conn, maxAnswerSize))
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("%s: %w", command, ctx.Err())
+		}
+		// the deadline of the connection may pass just before ctx is done
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return "", fmt.Errorf("%s: %w", command, context.DeadlineExceeded)
+		}
+		return "", fmt.Errorf("%s: %w", command, err)
+	}
+	return string(answer), nil
+}
+
+// ParseMonitor parses the answer to CommandMonitor, one tab separated key and
+// value per line.
+func ParseMonitor(answer string) map[string]string {
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(answer))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), "\t")
+		if found {
+			values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return values
+}
+
+// ParseServer parses the answer to CommandServer, one "Key: value" per line.
+// The first line holding the version is returned under "version".
+func ParseServer(answer string) map[string]string {
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(answer))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			continue
+		}
+		if strings.HasSuffix(key, "version") {
+			key = "version"
+		}
+		values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return values
+}
@@ -0,0 +1,231 @@
+package keeper
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubKeeper answers four letter word commands like a keeper: it reads the
+// command, writes the answer and closes the connection. Commands without an
+// answer are never answered nor closed.
+type stubKeeper struct {
+	listener net.Listener
+	answers  map[string]string
+
+	mu       sync.Mutex
+	commands []string
+}
+
+func newStubKeeper(t *testing.T, answers map[string]string) *stubKeeper {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := &stubKeeper{listener: listener, answers: answers}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer conn.Close()
+				stub.serve(conn)
+			}()
+		}
+	}()
+	t.Cleanup(func() {
+		listener.Close()
+		wg.Wait()
+	})
+	return stub
+}
+
+func (s *stubKeeper) serve(conn net.Conn) {
+	command := make([]byte, 4)
+	if _, err := io.ReadFull(conn, command); err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.commands = append(s.commands, string(command))
+	s.mu.Unlock()
+
+	answer, found := s.answers[string(command)]
+	if !found {
+		// hang until the client gives up
+		io.Copy(io.Discard, conn)
+		return
+	}
+	conn.Write([]byte(answer))
+}
+
+func (s *stubKeeper) address() string {
+	return s.listener.Addr().String()
+}
+
+func TestSendCommand(t *testing.T) {
+	stub := newStubKeeper(t, map[string]string{
+		CommandHealth:  HealthyAnswer,
+		CommandMonitor: "zk_version\tv24.8.1.1\nzk_avg_latency\t0\n",
+	})
+
+	for _, command := range []string{CommandHealth, CommandMonitor} {
+		answer, err := SendCommand(context.Background(), stub.address(), command)
+		if err != nil {
+			t.Fatalf("%s: %v", command, err)
+		}
+		if answer != stub.answers[command] {
+			t.Errorf("%s: got answer %q, want %q", command, answer, stub.answers[command])
+		}
+	}
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	if want := []string{CommandHealth, CommandMonitor}; !reflect.DeepEqual(stub.commands, want) {
+		t.Errorf("keeper received %q, want %q", stub.commands, want)
+	}
+}
+
+func TestSendCommandTimeout(t *testing.T) {
+	stub := newStubKeeper(t, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := SendCommand(ctx, stub.address(), CommandServer)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want the deadline to be exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("command returned after %s", elapsed)
+	}
+}
+
+func TestSendCommandUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	if _, err := SendCommand(context.Background(), address, CommandHealth); err == nil {
+		t.Error("sending a command to a closed port succeeded")
+	}
+}
+
+func TestParseMonitor(t *testing.T) {
+	tests := []struct {
+		name   string
+		answer string
+		want   map[string]string
+	}{
+		{
+			name: "clickhouse keeper",
+			answer: "zk_version\tv24.8.1.1-stable-abc\n" +
+				"zk_avg_latency\t1\n" +
+				"zk_packets_received\t120\n" +
+				"zk_server_state\tleader\n" +
+				"zk_followers\t2\n",
+			want: map[string]string{
+				"zk_version":          "v24.8.1.1-stable-abc",
+				"zk_avg_latency":      "1",
+				"zk_packets_received": "120",
+				"zk_server_state":     "leader",
+				"zk_followers":        "2",
+			},
+		},
+		{
+			name:   "spaces around keys and values",
+			answer: "  zk_znode_count \t 42 \r\nzk_watch_count\t0",
+			want:   map[string]string{"zk_znode_count": "42", "zk_watch_count": "0"},
+		},
+		{
+			name:   "lines without a tab are skipped",
+			answer: "This ZooKeeper instance is not currently serving requests\n",
+			want:   map[string]string{},
+		},
+		{
+			name:   "empty answer",
+			answer: "",
+			want:   map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseMonitor(tt.answer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseServer(t *testing.T) {
+	tests := []struct {
+		name   string
+		answer string
+		want   map[string]string
+	}{
+		{
+			name: "clickhouse keeper",
+			answer: "ClickHouse Keeper version: v24.8.1.1-stable-abc\n" +
+				"Latency min/avg/max: 0/0/3\n" +
+				"Received: 120\n" +
+				"Sent: 119\n" +
+				"Connections: 2\n" +
+				"Outstanding: 0\n" +
+				"Zxid: 1234\n" +
+				"Mode: leader\n" +
+				"Node count: 42\n",
+			want: map[string]string{
+				"version":             "v24.8.1.1-stable-abc",
+				"Latency min/avg/max": "0/0/3",
+				"Received":            "120",
+				"Sent":                "119",
+				"Connections":         "2",
+				"Outstanding":         "0",
+				"Zxid":                "1234",
+				"Mode":                "leader",
+				"Node count":          "42",
+			},
+		},
+		{
+			name: "zookeeper",
+			answer: "Zookeeper version: 3.8.4-9316c2a7a97e1666d8f4593f34dd6fc36ecc436c, built on 2024-02-12 22:16 UTC\n" +
+				"Zxid: 0x10000002a\n" +
+				"Mode: follower\n",
+			want: map[string]string{
+				"version": "3.8.4-9316c2a7a97e1666d8f4593f34dd6fc36ecc436c, built on 2024-02-12 22:16 UTC",
+				"Zxid":    "0x10000002a",
+				"Mode":    "follower",
+			},
+		},
+		{
+			name:   "lines without a colon are skipped",
+			answer: "This ZooKeeper instance is not currently serving requests\n\nZxid: 0x1\n",
+			want:   map[string]string{"Zxid": "0x1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseServer(tt.answer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
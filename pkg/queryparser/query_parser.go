@@ -1,6 +1,7 @@
 package queryparser
 
 import (
+	"regexp"
 	"strings"
 
 	"github.com/ClickHouse/clickhouse_exporter/pkg/yaml"
@@ -50,3 +51,55 @@ func makeFilterFromInterfaces(interfaces []interface{}) string {
 func makeFilterFromString(s string) string {
 	return "WHERE\n" + s
 }
+
+// Columns identifying the node a row comes from when a whole cluster is scraped.
+// getMacro('shard') would throw on nodes without that macro, so the shard and
+// replica come from system.clusters of the node running the query instead.
+// clusterAllReplicas makes every distinct host:port of the cluster a shard of its
+// own, numbered in the order of system.clusters, and _shard_num is that number.
+// Nodes missing from system.clusters get empty shard and replica labels.
+const (
+	clusterNodeColumns = `,
+	hostName() AS hostname,
+	tupleElement(arrayElement({CLUSTER_NODES}, _shard_num), 1) AS shard,
+	tupleElement(arrayElement({CLUSTER_NODES}, _shard_num), 2) AS replica`
+	clusterNodesQuery = `(SELECT groupArray((toString(shard_num), toString(replica_num))) FROM (
+		SELECT shard_num, replica_num FROM system.clusters WHERE cluster = '{CLUSTER}'
+		ORDER BY shard_num, replica_num LIMIT 1 BY host_name, port))`
+
+	// a query reading no system table runs on the local node only
+	localNodeColumns = `,
+	hostName() AS hostname,
+	'' AS shard,
+	'' AS replica`
+
+	clusterGroupBy = ", hostname, shard, replica"
+)
+
+var systemTablePattern = regexp.MustCompile(`(?i)\bFROM\s+system\.(\w+)`)
+
+// BuildQuery fills the placeholders of a collector query template. {FILTER_CLAUSE}
+// gets the filters of the yaml object. When cluster is set, system tables are read
+// through clusterAllReplicas and {CLUSTER_COLUMNS} and {CLUSTER_GROUP_BY} add the
+// hostname, shard and replica of each row, otherwise both are left empty.
+//
+// Only `FROM system.<table>` is rewritten. Any other table, e.g. one of a custom
+// collector, is read from the local node only and its rows get the hostname of
+// that node with empty shard and replica.
+func BuildQuery(template string, yamlObject yaml.YamlConfig, cluster string) string {
+	query := strings.Replace(template, "{FILTER_CLAUSE}", ParseYamlConfigToQueryFilter(yamlObject), 1)
+	if cluster == "" {
+		query = strings.ReplaceAll(query, "{CLUSTER_COLUMNS}", "")
+		return strings.ReplaceAll(query, "{CLUSTER_GROUP_BY}", "")
+	}
+
+	columns := localNodeColumns
+	if systemTablePattern.MatchString(query) {
+		quotedCluster := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(cluster)
+		query = systemTablePattern.ReplaceAllString(query, "FROM clusterAllReplicas('"+quotedCluster+"', system.$1)")
+		nodes := strings.ReplaceAll(clusterNodesQuery, "{CLUSTER}", quotedCluster)
+		columns = strings.ReplaceAll(clusterNodeColumns, "{CLUSTER_NODES}", nodes)
+	}
+	query = strings.ReplaceAll(query, "{CLUSTER_COLUMNS}", columns)
+	return strings.ReplaceAll(query, "{CLUSTER_GROUP_BY}", clusterGroupBy)
+}
@@ -2,6 +2,7 @@ package yaml
 
 import (
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -33,7 +34,10 @@ func (m *YamlConfig) GetMapObject(keys ...string) YamlConfig {
 			return YamlConfig{nil}
 		}
 	}
-	var parsed_value = current.(map[string]interface{})
+	parsed_value, ok := current.(map[string]interface{})
+	if !ok {
+		return YamlConfig{data: map[string]interface{}{}}
+	}
 	return YamlConfig{data: parsed_value}
 }
 
@@ -54,6 +58,115 @@ func (m *YamlConfig) Contains(key string) bool {
 	return exists
 }
 
+// GetBool returns the boolean stored under key, or defaultValue when the key is
+// missing or empty.
+func (m *YamlConfig) GetBool(key string, defaultValue bool) bool {
+	switch val := m.data[key].(type) {
+	case bool:
+		return val
+	case nil:
+		return defaultValue
+	default:
+		panic("error: " + key + " must be a boolean")
+	}
+}
+
+// GetString returns the string stored under key, or defaultValue when the key is
+// missing or empty.
+func (m *YamlConfig) GetString(key string, defaultValue string) string {
+	switch val := m.data[key].(type) {
+	case string:
+		return val
+	case nil:
+		return defaultValue
+	default:
+		panic("error: " + key + " must be a string")
+	}
+}
+
+// GetStringList returns the list of strings stored under key, or nil when the
+// key is missing or empty.
+func (m *YamlConfig) GetStringList(key string) []string {
+	switch val := m.data[key].(type) {
+	case []interface{}:
+		list := make([]string, 0, len(val))
+		for _, item := range val {
+			s, ok := item.(string)
+			if !ok {
+				panic("error: " + key + " must be a list of strings")
+			}
+			list = append(list, s)
+		}
+		return list
+	case nil:
+		return nil
+	default:
+		panic("error: " + key + " must be a list of strings")
+	}
+}
+
+// GetFloatList returns the list of numbers stored under key, or nil when the
+// key is missing or empty.
+func (m *YamlConfig) GetFloatList(key string) []float64 {
+	switch val := m.data[key].(type) {
+	case []interface{}:
+		list := make([]float64, 0, len(val))
+		for _, item := range val {
+			switch number := item.(type) {
+			case int:
+				list = append(list, float64(number))
+			case float64:
+				list = append(list, number)
+			default:
+				panic("error: " + key + " must be a list of numbers")
+			}
+		}
+		return list
+	case nil:
+		return nil
+	default:
+		panic("error: " + key + " must be a list of numbers")
+	}
+}
+
+// GetMapList returns the list of objects stored under key, or nil when the key
+// is missing or empty.
+func (m *YamlConfig) GetMapList(key string) []YamlConfig {
+	switch val := m.data[key].(type) {
+	case []interface{}:
+		list := make([]YamlConfig, 0, len(val))
+		for _, item := range val {
+			asMap, ok := item.(map[string]interface{})
+			if !ok {
+				panic("error: " + key + " must be a list of objects")
+			}
+			list = append(list, YamlConfig{data: asMap})
+		}
+		return list
+	case nil:
+		return nil
+	default:
+		panic("error: " + key + " must be a list of objects")
+	}
+}
+
+// GetDuration returns the duration stored under key, e.g. "5s", or defaultValue
+// when the key is missing or empty.
+func (m *YamlConfig) GetDuration(key string, defaultValue time.Duration) time.Duration {
+	switch val := m.data[key].(type) {
+	case string:
+		duration, err := time.ParseDuration(val)
+		if err != nil {
+			panic(err)
+		}
+		return duration
+	case nil:
+		return defaultValue
+	default:
+		panic("error: " + key + " must be a duration like 10s")
+	}
+}
+
 func (m *YamlConfig) GetData() map[string]interface{} {
 	return m.data
 }